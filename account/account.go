@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -14,46 +15,132 @@ import (
 )
 
 const (
-	erorKey       = "error_message"
-	idKey         = "id"
-	ClientTimeout = time.Duration(5 * time.Second) // for convenience
+	erorKey = "error_message"
+	idKey   = "id"
+	// ClientTimeout is a sensible http.Client.Timeout, which bounds every attempt on top of AttemptTimeout
+	ClientTimeout = time.Duration(5 * time.Second)
+	// DefaultMaxResponseSize is far above a full page of accounts and far below what a misbehaving proxy may send
+	DefaultMaxResponseSize = 10 << 20
 )
 
-// AccountClient All the bound methods are safe to run as coroutines
+// AccountClient All the bound methods are safe to run as coroutines. A call runs on the caller's goroutine and
+// leaves nothing running once it returns, but for the goroutine delivering the events of ClientOptions.Hooks;
+// every retry sends the full request body again.
 type AccountClient struct {
-	url         string
+	baseURL     *url.URL
 	timeout     time.Duration
 	contentType string
 	httpClient  *http.Client
 	logger      zerolog.Logger
+	options     ClientOptions
+	statuses    StatusPolicy
+	events      *dispatcher // nil without hooks
+}
+
+// ClientOptions tune NewAccountClientWithOptions; the zero value gives the defaults of NewAccountClient
+type ClientOptions struct {
+	// MaxResponseSize caps the bytes read from a response body; a larger body fails with a ResponseTooLargeError
+	// and is not retried. DefaultMaxResponseSize when not set. What the client does not read of a body is
+	// drained, so that the connection is reused.
+	MaxResponseSize int64
+	// Strict rejects successful responses that are not application/vnd.api+json or that carry JSON fields the
+	// models do not know, with an error matching ErrUnexpectedResponse; it catches the API drifting from the client
+	Strict bool
+	// StatusPolicy overrides what the client does with the status codes it lists, see DefaultStatusPolicy
+	StatusPolicy StatusPolicy
+	// AttemptTimeout bounds a single request and the reading of its response; an attempt that runs out of it is
+	// retried. OperationTimeout bounds a whole call, retries and backoffs included, on top of the caller's context.
+	// Both are unbounded when not set. A call that runs out of either, or of the caller's deadline, fails with a
+	// TimeoutError; http.Client.Timeout keeps applying to every attempt.
+	AttemptTimeout   time.Duration
+	OperationTimeout time.Duration
+	// Timeouts overrides AttemptTimeout and OperationTimeout for some operations, e.g. a longer one for list
+	Timeouts map[Operation]Timeouts
+	// Hooks are told about every attempt, retry and failed call, off the goroutine of the call
+	Hooks Hooks
+}
+
+// Timeouts are the time budgets of an operation; a zero one falls back to the one in ClientOptions
+type Timeouts struct {
+	Attempt   time.Duration
+	Operation time.Duration
+}
+
+// timeouts are the budgets a call of the operation runs under
+func (opts *ClientOptions) timeouts(op Operation) Timeouts {
+	timeouts := opts.Timeouts[op]
+	if timeouts.Attempt == 0 {
+		timeouts.Attempt = opts.AttemptTimeout
+	}
+	if timeouts.Operation == 0 {
+		timeouts.Operation = opts.OperationTimeout
+	}
+	return timeouts
 }
 
 // NewAccountClient create a client for a given host and with a specified http client. The timeout includes any
-// retries.
-func NewAccountClient(url string, client *http.Client) *AccountClient {
+// retries. The host address is validated here and may carry a base path, e.g. "https://gateway/accounts-api".
+func NewAccountClient(hostAddress string, client *http.Client) (*AccountClient, error) {
+	return NewAccountClientWithOptions(hostAddress, client, ClientOptions{})
+}
+
+// NewAccountClientWithOptions is NewAccountClient with the defaults of the client overridden by opts
+func NewAccountClientWithOptions(hostAddress string, client *http.Client, opts ClientOptions) (*AccountClient, error) {
+	baseURL, err := parseBaseURL(hostAddress)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxResponseSize <= 0 {
+		opts.MaxResponseSize = DefaultMaxResponseSize
+	}
+	if opts.AttemptTimeout < 0 || opts.OperationTimeout < 0 {
+		return nil, errors.New("invalid timeouts: they cannot be negative")
+	}
+	for op, timeouts := range opts.Timeouts {
+		if _, ok := routes[op]; !ok {
+			return nil, fmt.Errorf("invalid timeouts: unknown operation %q", op)
+		}
+		if timeouts.Attempt < 0 || timeouts.Operation < 0 {
+			return nil, fmt.Errorf("invalid timeouts of %s: they cannot be negative", op)
+		}
+	}
+	statuses, err := defaultStatusPolicy.merge(opts.StatusPolicy)
+	if err != nil {
+		return nil, err
+	}
+	// a shallow copy, so that the redirects are left to the status policy without changing the caller's client
+	httpClient := &http.Client{}
+	if client != nil {
+		*httpClient = *client
+	}
+	httpClient.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
 	newLogger := zerolog.New(os.Stderr).With().Timestamp().Logger()
 	ac := &AccountClient{
-		url:         url,
+		baseURL:     baseURL,
 		contentType: "application/vnd.api+json",
-		httpClient:  client,
+		httpClient:  httpClient,
 		logger:      newLogger,
+		options:     opts,
+		statuses:    statuses,
+	}
+	if opts.Hooks.any() {
+		ac.events = newDispatcher(opts.Hooks, &ac.logger)
 	}
 
-	return ac
+	return ac, nil
 }
 
 func (ac *AccountClient) GetById(ctx context.Context, accountId string) (*AccountData, error) {
-	request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v1/organisation/accounts/%s", ac.url, accountId), nil)
+	request, err := ac.newRequest(ctx, OperationGet, accountId, nil)
 	if err != nil {
-		return nil, fmt.Errorf("got an error while creating the request: %w", err)
+		return nil, err
 	}
 
-	result, err := ac.executeRequest(ctx, request)
-	if err != nil {
+	var body accountBody
+	if err := ac.executeRequest(ctx, OperationGet, request, &body); err != nil {
 		return nil, err
 	}
-	return result.accountData, err
-
+	return body.Data, nil
 }
 
 // CreateAccount upon succcessful account creation, returns the updated account object and a nil error
@@ -64,65 +151,97 @@ func (ac *AccountClient) CreateAccount(ctx context.Context, account *AccountData
 	}
 
 	buffer := bytes.NewBuffer(encoded)
-	request, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v1/organisation/accounts", ac.url), buffer)
+	request, err := ac.newRequest(ctx, OperationCreate, "", buffer)
 	if err != nil {
-		return &AccountData{}, fmt.Errorf("got an error while creating the request: %w", err)
+		return &AccountData{}, err
 	}
 
-	result, err := ac.executeRequest(ctx, request)
-	if err != nil {
+	var body accountBody
+	if err := ac.executeRequest(ctx, OperationCreate, request, &body); err != nil {
 		return &AccountData{}, err
 	}
-	return result.accountData, err
+	return body.Data, nil
 }
 
 func (ac *AccountClient) DeleteAccount(ctx context.Context, accountId string, version int64) error {
 
-	request, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/v1/organisation/accounts/%s", ac.url, accountId), nil)
+	request, err := ac.newRequest(ctx, OperationDelete, accountId, nil)
 	if err != nil {
-		return fmt.Errorf("got an error while creating the request: %w", err)
+		return err
 	}
 	querry := url.Values{}
 	querry.Add("version", fmt.Sprint(version))
 	request.URL.RawQuery = querry.Encode()
 
-	_, err = ac.executeRequest(ctx, request)
+	return ac.executeRequest(ctx, OperationDelete, request, nil)
+}
+
+// DefaultConflictRetries is how many times a delete that looked the version up refetches it after a conflict
+const DefaultConflictRetries = 3
+
+// DeleteOptions tune DeleteAccountWithOptions and DeleteIfExists
+type DeleteOptions struct {
+	FetchVersion bool // look the current version up with GetById instead of trusting the given one
+	// ConflictRetries is how many times a 409 version conflict on a looked up version is answered by refetching
+	// it and deleting again; DefaultConflictRetries when not set, a negative value for none. A given version is
+	// never replaced by a newer one: its conflict is returned as ErrConflict.
+	ConflictRetries int
+}
 
+// DeleteAccountWithOptions deletes an account for callers who may not know its current version
+func (ac *AccountClient) DeleteAccountWithOptions(ctx context.Context, accountId string, version int64, opts DeleteOptions) error {
+	if !opts.FetchVersion {
+		return ac.DeleteAccount(ctx, accountId, version)
+	}
+	if opts.ConflictRetries == 0 {
+		opts.ConflictRetries = DefaultConflictRetries
+	}
+	for conflicts := 0; ; conflicts++ {
+		current, err := ac.GetById(ctx, accountId)
+		if err != nil {
+			return err
+		}
+		version = current.Version
+
+		err = ac.DeleteAccount(ctx, accountId, version)
+		if !errors.Is(err, ErrConflict) || conflicts >= opts.ConflictRetries {
+			return err
+		}
+		ac.logger.Info().Str(idKey, accountId).Int64("version", version).Msg("Version conflict on delete, refetching the version")
+	}
+}
+
+// DeleteIfExists deletes whatever version of the account is current and treats a missing account as success
+func (ac *AccountClient) DeleteIfExists(ctx context.Context, accountId string, opts DeleteOptions) error {
+	opts.FetchVersion = true
+	err := ac.DeleteAccountWithOptions(ctx, accountId, 0, opts)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	return err
 }
 
 func (ac *AccountClient) UpdateAccount(ctx context.Context, account *AccountData) (*AccountData, error) {
-	encoded, err := json.Marshal(createRequestBody{Data: account})
+	encoded, err := json.Marshal(updateRequestBody{Data: updateData{AccountData: account, Version: account.Version}})
 	if err != nil {
 		return &AccountData{}, fmt.Errorf("could not json encode account data: %w", err)
 	}
 
 	buffer := bytes.NewBuffer(encoded)
-	request, err := http.NewRequestWithContext(ctx, "PATCH", fmt.Sprintf("%s/v1/organisation/account/%s", ac.url, account.ID), buffer)
+	request, err := ac.newRequest(ctx, OperationUpdate, account.ID, buffer)
 	if err != nil {
-		return &AccountData{}, fmt.Errorf("got an error while creating the request: %w", err)
+		return &AccountData{}, err
 	}
 	request.Header.Set("Accept", ac.contentType)
-	result, err := ac.executeRequest(ctx, request)
-	if err != nil {
+	var body accountBody
+	if err := ac.executeRequest(ctx, OperationUpdate, request, &body); err != nil {
 		return &AccountData{}, err
 	}
-	return result.accountData, err
+	return body.Data, nil
 }
 
-func (ac *AccountClient) executeRequest(ctx context.Context, req *http.Request) (*processedResult, error) {
+// executeRequest decodes a successful response body into out, unless out is nil
+func (ac *AccountClient) executeRequest(ctx context.Context, op Operation, req *http.Request, out interface{}) error {
 	req.Header.Set("Content-Type", ac.contentType)
-	respChan := make(chan *processedResult, 1)
-	ctxWithLogger := ac.logger.WithContext(ctx)
-	go handleRequest(ctxWithLogger, respChan, ac.httpClient, req)
-
-	select {
-	case <-ctx.Done():
-		return &processedResult{}, ctx.Err()
-	case result := <-respChan:
-		if result.err != nil {
-			return nil, result.err
-		}
-		return result, nil
-	}
+	return ac.handleRequest(ctx, op, req, out)
 }
@@ -0,0 +1,221 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultBulkConcurrency = 8
+
+// ErrBulkSkipped marks the items never sent because an earlier item failed and StopOnError was set
+var ErrBulkSkipped = errors.New("skipped after an earlier failure")
+
+// BulkOptions control the fan-out of the bulk operations
+type BulkOptions struct {
+	Concurrency int  // number of requests in flight; DefaultBulkConcurrency when not set
+	StopOnError bool // stop sending new requests after the first failure; in flight requests still finish
+	// OnResult, when set, is called with the result of each item as soon as it finishes, skipped items included,
+	// from the worker that ran it, so it must be safe for concurrent use. i indexes the input, once duplicate ids
+	// are dropped for GetAccounts and DeleteAccounts.
+	OnResult func(i int, result BulkResult)
+}
+
+// Outcome classifies the result of a bulk item so that expected misses are told apart from hard failures
+type Outcome string
+
+const (
+	OutcomeSucceeded Outcome = "succeeded"
+	OutcomeNotFound  Outcome = "not_found"
+	OutcomeConflict  Outcome = "conflict"
+	OutcomeSkipped   Outcome = "skipped"
+	OutcomeFailed    Outcome = "failed"
+)
+
+func classify(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSucceeded
+	case errors.Is(err, ErrNotFound):
+		return OutcomeNotFound
+	case errors.Is(err, ErrConflict):
+		return OutcomeConflict
+	case errors.Is(err, ErrBulkSkipped):
+		return OutcomeSkipped
+	default:
+		return OutcomeFailed
+	}
+}
+
+// BulkResult is the outcome of a single item: either the account or an error
+type BulkResult struct {
+	Account *AccountData
+	Err     error
+	Outcome Outcome
+}
+
+type BulkSummary struct {
+	Total     int
+	Succeeded int
+	NotFound  int
+	Conflict  int
+	Failed    int
+	Skipped   int
+	Duration  time.Duration
+}
+
+// IDVersion identifies the account version to delete
+type IDVersion struct {
+	ID      string
+	Version int64
+}
+
+// CreateAccounts creates the accounts on a bounded pool of workers. The results are in the order of the input.
+func (ac *AccountClient) CreateAccounts(ctx context.Context, accounts []*AccountData, opts BulkOptions) ([]BulkResult, BulkSummary) {
+	start := time.Now()
+	results := make([]BulkResult, len(accounts))
+	errs := runBulk(ctx, len(accounts), opts, func(ctx context.Context, i int) error {
+		created, err := ac.CreateAccount(ctx, accounts[i])
+		if err == nil {
+			results[i].Account = created
+		}
+		return err
+	}, func(i int, err error) BulkResult {
+		return BulkResult{Account: results[i].Account, Err: err, Outcome: classify(err)}
+	})
+
+	for i, err := range errs {
+		results[i].Err = err
+		results[i].Outcome = classify(err)
+	}
+	return results, summarize(errs, time.Since(start))
+}
+
+// GetAccounts fetches the accounts on a bounded pool of workers and returns the results keyed by account id
+func (ac *AccountClient) GetAccounts(ctx context.Context, ids []string, opts BulkOptions) (map[string]BulkResult, BulkSummary) {
+	start := time.Now()
+	ids = uniqueIDs(ids)
+	accounts := make([]*AccountData, len(ids))
+	errs := runBulk(ctx, len(ids), opts, func(ctx context.Context, i int) error {
+		fetched, err := ac.GetById(ctx, ids[i])
+		accounts[i] = fetched
+		return err
+	}, func(i int, err error) BulkResult {
+		return BulkResult{Account: accounts[i], Err: err, Outcome: classify(err)}
+	})
+
+	results := make(map[string]BulkResult, len(ids))
+	for i, err := range errs {
+		results[ids[i]] = BulkResult{Account: accounts[i], Err: err, Outcome: classify(err)}
+	}
+	return results, summarize(errs, time.Since(start))
+}
+
+// DeleteAccounts deletes the given account versions on a bounded pool of workers and returns the results keyed
+// by account id; a stale version is reported as OutcomeConflict and a missing account as OutcomeNotFound. An id
+// given more than once is deleted at its first version only.
+func (ac *AccountClient) DeleteAccounts(ctx context.Context, accounts []IDVersion, opts BulkOptions) (map[string]BulkResult, BulkSummary) {
+	start := time.Now()
+	accounts = uniqueVersions(accounts)
+	errs := runBulk(ctx, len(accounts), opts, func(ctx context.Context, i int) error {
+		return ac.DeleteAccount(ctx, accounts[i].ID, accounts[i].Version)
+	}, func(i int, err error) BulkResult {
+		return BulkResult{Err: err, Outcome: classify(err)}
+	})
+
+	results := make(map[string]BulkResult, len(accounts))
+	for i, err := range errs {
+		results[accounts[i].ID] = BulkResult{Err: err, Outcome: classify(err)}
+	}
+	return results, summarize(errs, time.Since(start))
+}
+
+func uniqueVersions(accounts []IDVersion) []IDVersion {
+	seen := make(map[string]bool, len(accounts))
+	unique := make([]IDVersion, 0, len(accounts))
+	for _, a := range accounts {
+		if !seen[a.ID] {
+			seen[a.ID] = true
+			unique = append(unique, a)
+		}
+	}
+	return unique
+}
+
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// runBulk calls do for every index in [0, n) with at most opts.Concurrency calls running at once and returns
+// the error of each call by index. result builds what opts.OnResult is given for an index once it finishes.
+func runBulk(ctx context.Context, n int, opts BulkOptions, do func(ctx context.Context, i int) error, result func(i int, err error) BulkResult) []error {
+	errs := make([]error, n)
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBulkConcurrency
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	var stopped int32
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				switch {
+				case atomic.LoadInt32(&stopped) == 1:
+					errs[i] = ErrBulkSkipped
+				case ctx.Err() != nil:
+					errs[i] = ctx.Err()
+				default:
+					errs[i] = do(ctx, i)
+					if errs[i] != nil && opts.StopOnError {
+						atomic.StoreInt32(&stopped, 1)
+					}
+				}
+				if opts.OnResult != nil {
+					opts.OnResult(i, result(i, errs[i]))
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return errs
+}
+
+func summarize(errs []error, duration time.Duration) BulkSummary {
+	summary := BulkSummary{Total: len(errs), Duration: duration}
+	for _, err := range errs {
+		switch classify(err) {
+		case OutcomeSucceeded:
+			summary.Succeeded++
+		case OutcomeNotFound:
+			summary.NotFound++
+		case OutcomeConflict:
+			summary.Conflict++
+		case OutcomeSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary
+}
@@ -0,0 +1,186 @@
+package account
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultHookQueueSize is how many events wait for the hooks before new ones are dropped
+const DefaultHookQueueSize = 1024
+
+// Hooks are told what the client does, e.g. to feed in-house telemetry. They run one at a time and in order on a
+// goroutine of the client, so they need no locking and a slow hook never holds a call back: when QueueSize
+// events are already waiting, new ones are dropped and counted by DroppedEvents. The goroutine only runs while
+// events wait for the hooks, so the client need not be closed; Close waits for the last ones to be delivered.
+type Hooks struct {
+	OnRequest  func(RequestEvent)  // before every attempt
+	OnResponse func(ResponseEvent) // after every attempt, whether it got a response or not
+	OnRetry    func(RetryEvent)    // before the backoff of a retry
+	OnGiveUp   func(GiveUpEvent)   // when a call fails, after its last attempt
+	QueueSize  int                 // DefaultHookQueueSize when not set
+}
+
+func (h *Hooks) any() bool {
+	return h.OnRequest != nil || h.OnResponse != nil || h.OnRetry != nil || h.OnGiveUp != nil
+}
+
+// RequestEvent is an attempt about to be sent; attempts are numbered from 1
+type RequestEvent struct {
+	Operation Operation
+	Attempt   int
+	Method    string
+	URL       string
+}
+
+// ResponseEvent is the outcome of an attempt; StatusCode is 0 when no response came back
+type ResponseEvent struct {
+	Operation  Operation
+	Attempt    int
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+// RetryEvent is an attempt that is sent again after Backoff
+type RetryEvent struct {
+	Operation  Operation
+	Attempt    int
+	StatusCode int
+	Backoff    time.Duration
+	Err        error
+}
+
+// GiveUpEvent is a call that failed; Latency covers all of its attempts and backoffs
+type GiveUpEvent struct {
+	Operation  Operation
+	Attempts   int
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+// dispatcher hands the events over to the hooks from its own goroutine, started by the first event that finds
+// it stopped and stopping once the queue is empty, so an idle client has nothing running
+type dispatcher struct {
+	dropped int64 // first, for the alignment of atomic operations
+	hooks   Hooks
+	logger  *zerolog.Logger
+	mu      sync.Mutex // the goroutine stops and the events start it under the lock, so none is left queued
+	running bool
+	closed  bool
+	queue   chan interface{}
+	idle    sync.WaitGroup // the goroutine, while it runs
+}
+
+func newDispatcher(hooks Hooks, logger *zerolog.Logger) *dispatcher {
+	size := hooks.QueueSize
+	if size <= 0 {
+		size = DefaultHookQueueSize
+	}
+	return &dispatcher{hooks: hooks, logger: logger, queue: make(chan interface{}, size)}
+}
+
+// emit queues the event without ever blocking; callers skip it for a client without hooks, which has no
+// dispatcher, so that they do not box events for nothing
+func (d *dispatcher) emit(event interface{}) {
+	if !d.wants(event) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		atomic.AddInt64(&d.dropped, 1)
+		return
+	}
+	select {
+	case d.queue <- event:
+	default:
+		atomic.AddInt64(&d.dropped, 1)
+		return
+	}
+	if !d.running {
+		d.running = true
+		d.idle.Add(1)
+		go d.run()
+	}
+}
+
+func (d *dispatcher) wants(event interface{}) bool {
+	switch event.(type) {
+	case RequestEvent:
+		return d.hooks.OnRequest != nil
+	case ResponseEvent:
+		return d.hooks.OnResponse != nil
+	case RetryEvent:
+		return d.hooks.OnRetry != nil
+	case GiveUpEvent:
+		return d.hooks.OnGiveUp != nil
+	}
+	return false
+}
+
+func (d *dispatcher) run() {
+	defer d.idle.Done()
+	for {
+		select {
+		case event := <-d.queue:
+			d.deliver(event)
+		default:
+			d.mu.Lock()
+			if len(d.queue) == 0 {
+				d.running = false
+				d.mu.Unlock()
+				return
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// deliver calls the hook of the event; a hook that panics loses its event rather than the client
+func (d *dispatcher) deliver(event interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger.Error().Str("type", "HookPanic").Msg(fmt.Sprint(r))
+		}
+	}()
+	switch e := event.(type) {
+	case RequestEvent:
+		d.hooks.OnRequest(e)
+	case ResponseEvent:
+		d.hooks.OnResponse(e)
+	case RetryEvent:
+		d.hooks.OnRetry(e)
+	case GiveUpEvent:
+		d.hooks.OnGiveUp(e)
+	}
+}
+
+// close waits for what is queued to be delivered; the events emitted after it are dropped
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.idle.Wait()
+}
+
+// DroppedEvents counts the events the hooks never got, because too many were waiting or the client was closed
+func (ac *AccountClient) DroppedEvents() int64 {
+	if ac.events == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&ac.events.dropped)
+}
+
+// Close waits for the events still queued to be delivered to the hooks, e.g. before a program exits. A client
+// without hooks has nothing to close; the calls made after Close still work, but their events are dropped.
+func (ac *AccountClient) Close() error {
+	if ac.events != nil {
+		ac.events.close()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const DefaultPageSize = 100
+
+// AccountPage is one page of the account list
+type AccountPage struct {
+	Accounts []*AccountData
+	Links    ListLinks
+}
+
+// ListAccounts fetches a single page of accounts; pages are numbered from 0
+func (ac *AccountClient) ListAccounts(ctx context.Context, pageNumber, pageSize int) (*AccountPage, error) {
+	request, err := ac.newRequest(ctx, OperationList, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Add("page[number]", fmt.Sprint(pageNumber))
+	query.Add("page[size]", fmt.Sprint(pageSize))
+	request.URL.RawQuery = query.Encode()
+
+	var body accountListBody
+	if err := ac.executeRequest(ctx, OperationList, request, &body); err != nil {
+		return nil, err
+	}
+	page := &AccountPage{Accounts: body.Data}
+	if body.Links != nil {
+		page.Links = *body.Links
+	}
+	return page, nil
+}
+
+// WalkAccounts calls fn for every account, one page at a time, until the list is exhausted or fn returns an error.
+// It follows the next link of each page, as the server may serve fewer accounts per page than asked for; without
+// links it walks the page numbers until an empty page comes back.
+func (ac *AccountClient) WalkAccounts(ctx context.Context, pageSize int, fn func(*AccountData) error) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	for pageNumber := 0; ; {
+		page, err := ac.ListAccounts(ctx, pageNumber, pageSize)
+		if err != nil {
+			return fmt.Errorf("could not list page %d: %w", pageNumber, err)
+		}
+		for _, acc := range page.Accounts {
+			if err := fn(acc); err != nil {
+				return err
+			}
+		}
+		switch {
+		case page.Links.Next != "":
+			if pageNumber, pageSize, err = nextPage(page.Links.Next, pageNumber, pageSize); err != nil {
+				return err
+			}
+		case page.Links.Self != "" || len(page.Accounts) == 0:
+			return nil
+		default:
+			pageNumber++
+		}
+	}
+}
+
+// nextPage reads the page number and size of a next link, keeping the size asked for when the link has none
+func nextPage(link string, pageNumber, pageSize int) (int, int, error) {
+	next, err := url.Parse(link)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid next link %q", ErrUnexpectedResponse, link)
+	}
+	query := next.Query()
+	number, err := strconv.Atoi(query.Get("page[number]"))
+	if err != nil || number <= pageNumber {
+		return 0, 0, fmt.Errorf("%w: next link %q does not point past page %d", ErrUnexpectedResponse, link, pageNumber)
+	}
+	if size, err := strconv.Atoi(query.Get("page[size]")); err == nil && size > 0 {
+		pageSize = size
+	}
+	return number, pageSize, nil
+}
@@ -1,5 +1,7 @@
 package account
 
+import "time"
+
 // Copied your models.go file but changed a json tag according to your API
 // specification here https://api-docs.form3.tech/api.html?python#organisation-accounts
 
@@ -8,7 +10,9 @@ const MaxBankCodeLength = 16
 
 type AccountData struct {
 	Attributes     *AccountAttributes `json:"attributes,omitempty"`
+	CreatedOn      *time.Time         `json:"created_on,omitempty"` // set by the server
 	ID             string             `json:"id,required"`
+	ModifiedOn     *time.Time         `json:"modified_on,omitempty"` // set by the server
 	OrganisationID string             `json:"organisation_id,omitempty"`
 	Type           string             `json:"type,omitempty"`
 	Version        int64              `json:"version,omitempty"`
@@ -32,19 +36,39 @@ type AccountAttributes struct {
 	Switched                bool     `json:"switched,omitempty"`
 }
 
-type createOkBody struct {
-	Data *AccountData `json:"data,required"`
+type accountBody struct {
+	Data  *AccountData `json:"data,required"`
+	Links *ListLinks   `json:"links,omitempty"` // only the self link
+}
+
+type accountListBody struct {
+	Data  []*AccountData `json:"data"`
+	Links *ListLinks     `json:"links,omitempty"`
+}
+
+// ListLinks are the pagination links of a list response
+type ListLinks struct {
+	First string `json:"first,omitempty"`
+	Last  string `json:"last,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Self  string `json:"self,omitempty"`
 }
 
 type createRequestBody struct {
 	Data *AccountData `json:"data,required"`
 }
 
-type createErrorBody struct {
-	ErrorMessage string `json:"error_message,required"`
+// updateData sends the version even when it is 0, which AccountData leaves out; the API requires it on PATCH
+type updateData struct {
+	*AccountData
+	Version int64 `json:"version"`
+}
+
+type updateRequestBody struct {
+	Data updateData `json:"data,required"`
 }
 
-type processedResult struct {
-	accountData *AccountData
-	err         error
+type createErrorBody struct {
+	ErrorMessage string `json:"error_message,required"`
 }
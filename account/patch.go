@@ -0,0 +1,157 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Optional is a tri-state field: absent (the zero value), explicitly null or set to a value.
+// Absent fields are left out of a patch body, null fields clear the value on the server.
+type Optional[T any] struct {
+	present bool
+	null    bool
+	value   T
+}
+
+// Set returns an Optional holding v; v may well be the zero value, e.g. false or ""
+func Set[T any](v T) Optional[T] {
+	return Optional[T]{present: true, value: v}
+}
+
+// Null returns an Optional that serialises to a JSON null
+func Null[T any]() Optional[T] {
+	return Optional[T]{present: true, null: true}
+}
+
+func (o Optional[T]) IsPresent() bool {
+	return o.present
+}
+
+func (o Optional[T]) IsNull() bool {
+	return o.present && o.null
+}
+
+// Value returns the held value and whether there is one; absent and null fields both report false
+func (o Optional[T]) Value() (T, bool) {
+	return o.value, o.present && !o.null
+}
+
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if !o.present || o.null {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.value)
+}
+
+// UnmarshalJSON is only called for keys present in the document, so an absent key stays absent
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*o = Null[T]()
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*o = Set(v)
+	return nil
+}
+
+// AccountPatch describes a partial update of the account attributes. Only the present fields are sent,
+// so booleans can be turned off and strings cleared, which is not possible with AccountAttributes.
+type AccountPatch struct {
+	AccountClassification   Optional[string]   `json:"account_classification"`
+	AccountMatchingOptOut   Optional[bool]     `json:"account_matching_opt_out"`
+	AccountNumber           Optional[string]   `json:"account_number"`
+	AlternativeNames        Optional[[]string] `json:"alternative_names"`
+	BankID                  Optional[string]   `json:"bank_id"`
+	BankIDCode              Optional[string]   `json:"bank_id_code"`
+	BaseCurrency            Optional[string]   `json:"base_currency"`
+	Bic                     Optional[string]   `json:"bic"`
+	Country                 Optional[string]   `json:"country"`
+	Iban                    Optional[string]   `json:"iban"`
+	JointAccount            Optional[bool]     `json:"joint_account"`
+	Name                    Optional[[]string] `json:"name"`
+	SecondaryIdentification Optional[string]   `json:"secondary_identification"`
+	Status                  Optional[string]   `json:"status"`
+	Switched                Optional[bool]     `json:"switched"`
+}
+
+type presentField interface {
+	IsPresent() bool
+}
+
+// fields lists the patch entries by their json key; keep it in sync with the struct above
+func (p *AccountPatch) fields() map[string]presentField {
+	return map[string]presentField{
+		"account_classification":   p.AccountClassification,
+		"account_matching_opt_out": p.AccountMatchingOptOut,
+		"account_number":           p.AccountNumber,
+		"alternative_names":        p.AlternativeNames,
+		"bank_id":                  p.BankID,
+		"bank_id_code":             p.BankIDCode,
+		"base_currency":            p.BaseCurrency,
+		"bic":                      p.Bic,
+		"country":                  p.Country,
+		"iban":                     p.Iban,
+		"joint_account":            p.JointAccount,
+		"name":                     p.Name,
+		"secondary_identification": p.SecondaryIdentification,
+		"status":                   p.Status,
+		"switched":                 p.Switched,
+	}
+}
+
+// IsEmpty reports whether the patch would not change anything
+func (p *AccountPatch) IsEmpty() bool {
+	for _, f := range p.fields() {
+		if f.IsPresent() {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON renders a JSON merge patch: absent fields are omitted, null fields are sent as null
+func (p AccountPatch) MarshalJSON() ([]byte, error) {
+	present := make(map[string]presentField)
+	for key, f := range p.fields() {
+		if f.IsPresent() {
+			present[key] = f
+		}
+	}
+	return json.Marshal(present) // map keys are sorted so the output is stable
+}
+
+type patchData struct {
+	ID         string        `json:"id"`
+	Type       string        `json:"type"`
+	Version    int64         `json:"version"`
+	Attributes *AccountPatch `json:"attributes,omitempty"`
+}
+
+type patchRequestBody struct {
+	Data *patchData `json:"data"`
+}
+
+// PatchAccount applies a partial update to the account at the given version and returns the updated account
+func (ac *AccountClient) PatchAccount(ctx context.Context, accountId string, version int64, patch *AccountPatch) (*AccountData, error) {
+	encoded, err := json.Marshal(patchRequestBody{Data: &patchData{ID: accountId, Type: "accounts", Version: version, Attributes: patch}})
+	if err != nil {
+		return &AccountData{}, fmt.Errorf("could not json encode account patch: %w", err)
+	}
+
+	buffer := bytes.NewBuffer(encoded)
+	request, err := ac.newRequest(ctx, OperationUpdate, accountId, buffer)
+	if err != nil {
+		return &AccountData{}, err
+	}
+	request.Header.Set("Accept", ac.contentType)
+	var body accountBody
+	if err := ac.executeRequest(ctx, OperationUpdate, request, &body); err != nil {
+		return &AccountData{}, err
+	}
+	return body.Data, nil
+}
@@ -0,0 +1,100 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Operation names an API call of the client
+type Operation string
+
+const (
+	OperationGet    Operation = "get"
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+	OperationList   Operation = "list"
+)
+
+const accountsPath = "/v1/organisation/accounts"
+
+// ErrInvalidAccountID is returned before any request is made when an account id is not a canonical uuid
+var ErrInvalidAccountID = errors.New("account id is not a valid uuid")
+
+type route struct {
+	method string
+	withID bool // the account id is appended to the collection path
+}
+
+// routes is the single place where the API endpoints are described
+var routes = map[Operation]route{
+	OperationGet:    {method: http.MethodGet, withID: true},
+	OperationCreate: {method: http.MethodPost, withID: false},
+	OperationUpdate: {method: http.MethodPatch, withID: true},
+	OperationDelete: {method: http.MethodDelete, withID: true},
+	OperationList:   {method: http.MethodGet, withID: false},
+}
+
+// parseBaseURL validates the API host address once; a path in it is kept as a prefix, which is
+// what gateways mounting the API under e.g. /accounts-api need
+func parseBaseURL(rawURL string) (*url.URL, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url %q: %w", rawURL, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url %q: scheme must be http or https", rawURL)
+	}
+	if base.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q: missing host", rawURL)
+	}
+	if base.RawQuery != "" || base.Fragment != "" {
+		return nil, fmt.Errorf("invalid base url %q: query and fragment are not allowed", rawURL)
+	}
+	prefix := strings.TrimRight(base.EscapedPath(), "/")
+	base.Path, err = url.PathUnescape(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url %q: %w", rawURL, err)
+	}
+	base.RawPath = prefix
+	return base, nil
+}
+
+func validateAccountID(accountId string) error {
+	// uuid.Parse also accepts the urn and braced forms which have no place in a path
+	if _, err := uuid.Parse(accountId); err != nil || len(accountId) != 36 {
+		return fmt.Errorf("%w: %q", ErrInvalidAccountID, accountId)
+	}
+	return nil
+}
+
+// newRequest builds the request for an operation from the route table
+func (ac *AccountClient) newRequest(ctx context.Context, op Operation, accountId string, body io.Reader) (*http.Request, error) {
+	r, ok := routes[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %q", op)
+	}
+	escapedPath := ac.baseURL.RawPath + accountsPath
+	if r.withID {
+		if err := validateAccountID(accountId); err != nil {
+			return nil, err
+		}
+		escapedPath += "/" + url.PathEscape(accountId)
+	}
+	endpoint := *ac.baseURL
+	endpoint.RawPath = escapedPath
+	endpoint.Path, _ = url.PathUnescape(escapedPath) // every segment was escaped above
+
+	request, err := http.NewRequestWithContext(ctx, r.method, endpoint.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("got an error while creating the request: %w", err)
+	}
+	return request, nil
+}
@@ -0,0 +1,87 @@
+package account
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// StatusAction is what the client does with a response, by its status code
+type StatusAction string
+
+const (
+	// StatusSucceed decodes the body, if the call returns one
+	StatusSucceed StatusAction = "succeed"
+	// StatusFail returns a ResponseError with the message of the body
+	StatusFail StatusAction = "fail"
+	// StatusRetry sends the request again after a backoff, until the context ends
+	StatusRetry StatusAction = "retry"
+	// StatusRedirect sends the same request, method and body included, to the Location of the response
+	StatusRedirect StatusAction = "redirect"
+)
+
+// maxRedirects bounds the redirects followed by one attempt, the same as net/http does
+const maxRedirects = 10
+
+// StatusPolicy maps status codes to what the client does with them. ClientOptions.StatusPolicy overrides the
+// default for the codes it has; codes in neither fail.
+type StatusPolicy map[int]StatusAction
+
+// defaultStatusPolicy is the single place where the statuses of the API are classified
+var defaultStatusPolicy = StatusPolicy{
+	http.StatusOK:        StatusSucceed,
+	http.StatusCreated:   StatusSucceed,
+	http.StatusNoContent: StatusSucceed, // can receive this on DELETE
+
+	// the gateway answers these during maintenance windows; 301, 302 and 303 let a client turn a POST into a
+	// GET, so they are not followed unless a policy says so
+	http.StatusTemporaryRedirect: StatusRedirect,
+	http.StatusPermanentRedirect: StatusRedirect,
+
+	http.StatusRequestTimeout:      StatusRetry,
+	http.StatusTooManyRequests:     StatusRetry,
+	http.StatusInternalServerError: StatusRetry,
+	http.StatusBadGateway:          StatusRetry,
+	http.StatusServiceUnavailable:  StatusRetry,
+	http.StatusGatewayTimeout:      StatusRetry,
+	// 400, 401, 403, 404, 405, 406, 409, 410, 412, 422, 501 and all the others fail
+}
+
+// DefaultStatusPolicy returns a copy of the statuses the client does not fail on by default:
+//
+//	200, 201, 204                succeed
+//	307, 308                     redirect
+//	408, 429, 500, 502, 503, 504 retry
+func DefaultStatusPolicy() StatusPolicy {
+	policy := make(StatusPolicy, len(defaultStatusPolicy))
+	for code, action := range defaultStatusPolicy {
+		policy[code] = action
+	}
+	return policy
+}
+
+// merge overrides the defaults with the given policy after checking it
+func (p StatusPolicy) merge(overrides StatusPolicy) (StatusPolicy, error) {
+	merged := make(StatusPolicy, len(p)+len(overrides))
+	for code, action := range p {
+		merged[code] = action
+	}
+	for code, action := range overrides {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status policy: %d is not a status code", code)
+		}
+		switch action {
+		case StatusSucceed, StatusFail, StatusRetry, StatusRedirect:
+		default:
+			return nil, fmt.Errorf("invalid status policy: unknown action %q for %d", action, code)
+		}
+		merged[code] = action
+	}
+	return merged, nil
+}
+
+func (p StatusPolicy) action(code int) StatusAction {
+	if action, ok := p[code]; ok {
+		return action
+	}
+	return StatusFail
+}
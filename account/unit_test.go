@@ -6,16 +6,28 @@ package account
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newTestClient(t *testing.T, hostAddress string, httpClient *http.Client) *AccountClient {
+	client, err := NewAccountClient(hostAddress, httpClient)
+	require.NoError(t, err)
+	return client
+}
+
 // Retry on receiving a 5xx code from the server
 func TestCreateAccountSucceedsAfter5xxResponse(t *testing.T) {
 	// WHEN
@@ -37,7 +49,7 @@ func TestCreateAccountSucceedsAfter5xxResponse(t *testing.T) {
 	// THEN
 
 	httpClient := &http.Client{Timeout: ClientTimeout}
-	client := NewAccountClient(serverWithInternalError.URL, httpClient)
+	client := newTestClient(t, serverWithInternalError.URL, httpClient)
 	acc, err := client.CreateAccount(ctx, &AccountData{})
 	assert.Equal(t, testId, acc.ID)
 	assert.Nil(t, err)
@@ -58,12 +70,99 @@ func TestCreateAccountFailsWith5xxResponse(t *testing.T) {
 	// THEN
 	timeout := time.Duration(2 * time.Second)
 	httpClient := &http.Client{Timeout: timeout}
-	client := NewAccountClient(serverWithInternalError.URL, httpClient)
+	client := newTestClient(t, serverWithInternalError.URL, httpClient)
 	_, err := client.CreateAccount(ctx, &AccountData{})
 	assert.Error(t, err)
 	assert.True(t, strings.Contains(err.Error(), "context deadline exceeded"))
 }
 
+type countingTransport struct {
+	attempts int32
+	after    func() // called once an attempt got its response
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.attempts, 1)
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if c.after != nil {
+		c.after()
+	}
+	return resp, err
+}
+
+// The retries stop with the caller's context instead of running on in the background: the call returns as soon
+// as the context ends, and being synchronous it has nothing left to send
+func TestRetriesStopWhenTheContextEnds(t *testing.T) {
+	// WHEN
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+
+	// THEN
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	transport := &countingTransport{after: cancel} // cancelled before the first backoff
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout, Transport: transport})
+	start := time.Now()
+	_, err := client.GetById(ctx, id)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 400*time.Millisecond, "the backoff is not slept out")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&transport.attempts))
+
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelDeadline()
+	transport = &countingTransport{}
+	client = newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout, Transport: transport})
+	start = time.Now()
+	_, err = client.GetById(deadline, id)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 400*time.Millisecond, "no backoff fits the deadline")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&transport.attempts))
+}
+
+// attemptRecorder keeps the body of every attempt and fails the first ones, the way a recording or signing
+// transport sees the requests before they reach the network
+type attemptRecorder struct {
+	failures int
+	bodies   []string
+}
+
+func (a *attemptRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(r.Body)
+	a.bodies = append(a.bodies, string(body))
+	status := 201
+	if len(a.bodies) <= a.failures {
+		status, body = 503, nil
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewReader(body)), Request: r}, nil
+}
+
+// Every retry sends the full body again, not the reader drained by the first attempt
+func TestRetriesResendTheBody(t *testing.T) {
+	// WHEN
+	transport := &attemptRecorder{failures: 2}
+	client := newTestClient(t, "http://api.test", &http.Client{Transport: transport})
+	data := &AccountData{ID: "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc", Type: "accounts", Attributes: &AccountAttributes{Country: "GB"}}
+
+	// THEN
+	created, err := client.CreateAccount(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, data, created)
+	require.Len(t, transport.bodies, 3)
+	assert.NotEmpty(t, transport.bodies[0])
+	assert.Equal(t, transport.bodies[0], transport.bodies[1])
+	assert.Equal(t, transport.bodies[0], transport.bodies[2])
+
+	transport = &attemptRecorder{failures: 1}
+	client = newTestClient(t, "http://api.test", &http.Client{Transport: transport})
+	_, err = client.PatchAccount(context.Background(), data.ID, 0, &AccountPatch{Country: Set("FR")})
+	require.NoError(t, err)
+	require.Len(t, transport.bodies, 2)
+	assert.Equal(t, transport.bodies[0], transport.bodies[1])
+}
+
 // Handles requests before the context's timeout
 func TestCreateAccountSuceedsWhenServerRespondsSlowly(t *testing.T) {
 	// WHEN
@@ -78,7 +177,7 @@ func TestCreateAccountSuceedsWhenServerRespondsSlowly(t *testing.T) {
 
 	// THEN
 	httpClient := &http.Client{Timeout: timeout}
-	client := NewAccountClient(serverTakesTooLongToRepond.URL, httpClient)
+	client := newTestClient(t, serverTakesTooLongToRepond.URL, httpClient)
 	acc, err := client.CreateAccount(ctx, &AccountData{}) // acount data does not matter in this case
 	assert.Nil(t, err)
 	assert.Equal(t, "dummy id", acc.ID)
@@ -94,7 +193,7 @@ func TestClientWithoutTimeoutWaitsIndefinitely(t *testing.T) {
 	}))
 	defer serverTakesTooLongToRepond.Close()
 	httpClient := &http.Client{}
-	client := NewAccountClient(serverTakesTooLongToRepond.URL, httpClient)
+	client := newTestClient(t, serverTakesTooLongToRepond.URL, httpClient)
 	var buf bytes.Buffer
 	client.logger = client.logger.Output(&buf) // redirect logs to a buffer so we can assert them
 	ctx := context.Background()
@@ -104,8 +203,1133 @@ func TestClientWithoutTimeoutWaitsIndefinitely(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "dummy id", acc.ID)
 
-	fetchedData, err := client.GetById(ctx, "does not matter")
+	fetchedData, err := client.GetById(ctx, "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc") // any well formed id will do
 	assert.Nil(t, err)
 	assert.Equal(t, fetchedData.ID, "dummy id")
 	assert.Equal(t, 0, buf.Len())
 }
+
+// Only the present patch fields are serialised and nulls are kept
+func TestAccountPatchSerialisesMinimalBody(t *testing.T) {
+	// WHEN
+	patch := AccountPatch{
+		Switched:              Set(false),
+		AccountMatchingOptOut: Set(false),
+		Bic:                   Null[string](),
+		Name:                  Set([]string{"Jane", "Doe"}),
+	}
+
+	// THEN
+	encoded, err := json.Marshal(patch)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"account_matching_opt_out":false,"bic":null,"name":["Jane","Doe"],"switched":false}`, string(encoded))
+	assert.False(t, patch.IsEmpty())
+	assert.True(t, (&AccountPatch{}).IsEmpty())
+
+	var decoded AccountPatch
+	assert.Nil(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, patch, decoded)
+}
+
+// PatchAccount sends the merge patch wrapped in the JSON:API envelope
+func TestPatchAccountSendsOnlyChangedFields(t *testing.T) {
+	// WHEN
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(200)
+		w.Write([]byte(`{"data": {"id": "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc", "version": 1}}`))
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+
+	// THEN
+	acc, err := client.PatchAccount(context.Background(), "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc", 0, &AccountPatch{JointAccount: Set(false)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), acc.Version)
+	assert.JSONEq(t, `{"data": {"id": "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc", "type": "accounts", "version": 0, "attributes": {"joint_account": false}}}`, string(receivedBody))
+}
+
+// Every operation hits the documented method and path
+func TestRoutesContract(t *testing.T) {
+	// WHEN
+	type call struct {
+		method string
+		path   string
+	}
+	calls := make(chan call, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- call{r.Method, r.URL.Path}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(204)
+			return
+		}
+		w.WriteHeader(200)
+		if r.URL.Query().Get("page[size]") != "" {
+			w.Write([]byte(`{"data": []}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"id": "dummy id"}}`))
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+	ctx := context.Background()
+	accountId := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+
+	cases := []struct {
+		operation Operation
+		do        func() error
+		expected  call
+	}{
+		{OperationGet, func() error { _, err := client.GetById(ctx, accountId); return err },
+			call{"GET", "/v1/organisation/accounts/" + accountId}},
+		{OperationCreate, func() error { _, err := client.CreateAccount(ctx, &AccountData{ID: accountId}); return err },
+			call{"POST", "/v1/organisation/accounts"}},
+		{OperationUpdate, func() error { _, err := client.UpdateAccount(ctx, &AccountData{ID: accountId}); return err },
+			call{"PATCH", "/v1/organisation/accounts/" + accountId}},
+		{OperationUpdate, func() error { _, err := client.PatchAccount(ctx, accountId, 0, &AccountPatch{}); return err },
+			call{"PATCH", "/v1/organisation/accounts/" + accountId}},
+		{OperationDelete, func() error { return client.DeleteAccount(ctx, accountId, 0) },
+			call{"DELETE", "/v1/organisation/accounts/" + accountId}},
+		{OperationList, func() error { _, err := client.ListAccounts(ctx, 0, 10); return err },
+			call{"GET", "/v1/organisation/accounts"}},
+	}
+	covered := make(map[Operation]bool)
+
+	// THEN
+	for _, tc := range cases {
+		t.Run(string(tc.operation), func(t *testing.T) {
+			assert.Nil(t, tc.do())
+			assert.Equal(t, tc.expected, <-calls)
+		})
+		covered[tc.operation] = true
+	}
+	for op := range routes {
+		assert.True(t, covered[op], "operation %s has no contract case", op)
+	}
+}
+
+// The base url is validated once and its path is kept as a prefix for every route
+func TestBaseURLAndAccountIDHandling(t *testing.T) {
+	// WHEN
+	paths := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+		w.WriteHeader(200)
+		w.Write([]byte(`{"data": {"id": "dummy id"}}`))
+	}))
+	defer server.Close()
+	accountId := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	ctx := context.Background()
+
+	// THEN
+	for _, hostAddress := range []string{server.URL, server.URL + "/"} {
+		client := newTestClient(t, hostAddress, &http.Client{Timeout: ClientTimeout})
+		_, err := client.GetById(ctx, accountId)
+		assert.Nil(t, err)
+		assert.Equal(t, "/v1/organisation/accounts/"+accountId, <-paths)
+	}
+
+	gatewayClient := newTestClient(t, server.URL+"/gateway/accounts%20api/", &http.Client{Timeout: ClientTimeout})
+	_, err := gatewayClient.GetById(ctx, accountId)
+	assert.Nil(t, err)
+	assert.Equal(t, "/gateway/accounts%20api/v1/organisation/accounts/"+accountId, <-paths)
+
+	for _, invalidId := range []string{"", "../../health", "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc/x", "?version=1", "{ad27e265-9605-4b4b-a0e5-3003ea9cc4dc}"} {
+		_, err := gatewayClient.GetById(ctx, invalidId)
+		assert.ErrorIs(t, err, ErrInvalidAccountID)
+		assert.ErrorIs(t, gatewayClient.DeleteAccount(ctx, invalidId, 0), ErrInvalidAccountID)
+	}
+	assert.Len(t, paths, 0, "no request should reach the server with an invalid id")
+
+	for _, invalidHost := range []string{"localhost:8080", "ftp://localhost", "http://", "http://localhost?x=1", "http://localhost/#top"} {
+		_, err := NewAccountClient(invalidHost, &http.Client{})
+		assert.Error(t, err, invalidHost)
+	}
+}
+
+// A delete that loses a race against another writer refetches the version and tries again
+func TestDeleteAccountRefetchesVersionOnConflict(t *testing.T) {
+	// WHEN
+	accountId := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	version := 3
+	deleted := false
+	deleteAttempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deleted {
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf(`{"error_message": "record %s does not exist"}`, accountId)))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.WriteHeader(200)
+			w.Write([]byte(fmt.Sprintf(`{"data": {"id": "%s", "version": %d}}`, accountId, version)))
+		case http.MethodDelete:
+			deleteAttempts++
+			if r.URL.Query().Get("version") != fmt.Sprint(version) {
+				w.WriteHeader(409)
+				w.Write([]byte(`{"error_message": "invalid version"}`))
+				version++ // somebody else keeps touching the record
+				return
+			}
+			deleted = true
+			w.WriteHeader(204)
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+	ctx := context.Background()
+
+	// THEN
+	err := client.DeleteAccount(ctx, accountId, 0)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.EqualError(t, err, "response status code 409 with error message: invalid version")
+
+	err = client.DeleteAccountWithOptions(ctx, accountId, 0, DeleteOptions{ConflictRetries: 1})
+	assert.ErrorIs(t, err, ErrConflict, "a given version is never replaced by a newer one")
+	assert.Equal(t, 2, deleteAttempts)
+
+	err = client.DeleteAccountWithOptions(ctx, accountId, 0, DeleteOptions{FetchVersion: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, deleteAttempts)
+
+	err = client.DeleteAccountWithOptions(ctx, accountId, 0, DeleteOptions{FetchVersion: true})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, client.DeleteIfExists(ctx, accountId, DeleteOptions{}))
+}
+
+// A write landing between the version lookup and the delete is retried by default, unlike a conflict on a given
+// version
+func TestDeleteIfExistsRetriesAConcurrentUpdate(t *testing.T) {
+	// WHEN
+	accountId := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	version := 0
+	var gets, deletes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			gets++
+			w.WriteHeader(200)
+			w.Write([]byte(fmt.Sprintf(`{"data": {"id": "%s", "version": %d}}`, accountId, version)))
+		case http.MethodDelete:
+			deletes++
+			if deletes == 1 {
+				version++ // updated by somebody else after it was fetched
+			}
+			if r.URL.Query().Get("version") != fmt.Sprint(version) {
+				w.WriteHeader(409)
+				w.Write([]byte(`{"error_message": "invalid version"}`))
+				return
+			}
+			w.WriteHeader(204)
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+	ctx := context.Background()
+
+	// THEN
+	assert.Nil(t, client.DeleteIfExists(ctx, accountId, DeleteOptions{}))
+	assert.Equal(t, 2, gets)
+	assert.Equal(t, 2, deletes)
+
+	gets, deletes = 0, 0
+	err := client.DeleteAccountWithOptions(ctx, accountId, int64(version), DeleteOptions{})
+	assert.ErrorIs(t, err, ErrConflict, "the version given is the one deleted or none")
+	assert.Equal(t, 0, gets)
+	assert.Equal(t, 1, deletes)
+}
+
+// Bulk creates never exceed the configured concurrency and keep the input order
+func TestCreateAccountsIsBoundedAndOrdered(t *testing.T) {
+	// WHEN
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		var body createRequestBody
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Data.ID == "bad" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error_message": "id in body must be of type uuid: \"bad\""}`))
+			return
+		}
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(accountBody{Data: body.Data})
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+	accounts := make([]*AccountData, 20)
+	for i := range accounts {
+		accounts[i] = &AccountData{ID: fmt.Sprint(i)}
+	}
+	accounts[5].ID = "bad"
+
+	// THEN
+	results, summary := client.CreateAccounts(context.Background(), accounts, BulkOptions{Concurrency: 3})
+	assert.LessOrEqual(t, maxInFlight, int32(3))
+	assert.Equal(t, BulkSummary{Total: 20, Succeeded: 19, Failed: 1, Duration: summary.Duration}, summary)
+	for i, result := range results {
+		if i == 5 {
+			assert.EqualError(t, result.Err, `response status code 400 with error message: id in body must be of type uuid: "bad"`)
+			continue
+		}
+		assert.Nil(t, result.Err)
+		assert.Equal(t, fmt.Sprint(i), result.Account.ID)
+	}
+
+	var reported []Outcome
+	onResult := func(i int, result BulkResult) { reported = append(reported, result.Outcome) }
+	results, summary = client.CreateAccounts(context.Background(), accounts, BulkOptions{Concurrency: 1, StopOnError: true, OnResult: onResult})
+	assert.Equal(t, BulkSummary{Total: 20, Succeeded: 5, Failed: 1, Skipped: 14, Duration: summary.Duration}, summary)
+	assert.ErrorIs(t, results[19].Err, ErrBulkSkipped)
+	assert.Len(t, reported, 20)
+	assert.Equal(t, []Outcome{OutcomeSucceeded, OutcomeFailed, OutcomeSkipped}, reported[4:7])
+}
+
+// Bulk reads and deletes tell missing accounts and stale versions apart from hard failures
+func TestGetAndDeleteAccountsClassifyOutcomes(t *testing.T) {
+	// WHEN
+	existing := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	missing := "0d27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, missing):
+			w.WriteHeader(404)
+			w.Write([]byte(fmt.Sprintf(`{"error_message": "record %s does not exist"}`, missing)))
+		case r.Method == http.MethodGet:
+			w.WriteHeader(200)
+			w.Write([]byte(fmt.Sprintf(`{"data": {"id": "%s", "version": 1}}`, existing)))
+		case r.URL.Query().Get("version") != "1":
+			w.WriteHeader(409)
+			w.Write([]byte(`{"error_message": "invalid version"}`))
+		default:
+			w.WriteHeader(204)
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+	ctx := context.Background()
+
+	// THEN
+	fetched, summary := client.GetAccounts(ctx, []string{existing, missing, existing, "not a uuid"}, BulkOptions{})
+	assert.Len(t, fetched, 3)
+	assert.Equal(t, OutcomeSucceeded, fetched[existing].Outcome)
+	assert.Equal(t, int64(1), fetched[existing].Account.Version)
+	assert.Equal(t, OutcomeNotFound, fetched[missing].Outcome)
+	assert.Equal(t, OutcomeFailed, fetched["not a uuid"].Outcome)
+	assert.Equal(t, BulkSummary{Total: 3, Succeeded: 1, NotFound: 1, Failed: 1, Duration: summary.Duration}, summary)
+
+	deleted, summary := client.DeleteAccounts(ctx, []IDVersion{{existing, 0}, {missing, 0}, {existing, 1}}, BulkOptions{Concurrency: 2})
+	assert.Len(t, deleted, 2)
+	assert.Equal(t, OutcomeConflict, deleted[existing].Outcome, "the first version of a repeated id is the one deleted")
+	assert.ErrorIs(t, deleted[existing].Err, ErrConflict)
+	assert.Equal(t, OutcomeNotFound, deleted[missing].Outcome)
+	assert.Equal(t, BulkSummary{Total: 2, NotFound: 1, Conflict: 1, Duration: summary.Duration}, summary)
+}
+
+// Client side validation reports the first broken rule the way the API words it
+func TestValidate(t *testing.T) {
+	valid := func() *AccountData {
+		return &AccountData{
+			ID:             "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc",
+			OrganisationID: "eb0bd6f5-c3f5-44b2-b677-acd23cdde73c",
+			Type:           "accounts",
+			Attributes:     &AccountAttributes{Country: "GB", Name: []string{"John", "Doe"}},
+		}
+	}
+	cases := []struct {
+		name     string
+		mutate   func(a *AccountData)
+		expected string
+	}{
+		{"valid", func(a *AccountData) {}, ""},
+		{"invalid id", func(a *AccountData) { a.ID = "invalid-id" }, `id in body must be of type uuid: "invalid-id"`},
+		{"invalid type", func(a *AccountData) { a.Type = "invalid type" }, "type in body should be one of [accounts]"},
+		{"missing country", func(a *AccountData) { a.Attributes.Country = "" }, "country in body is required"},
+		{"invalid country", func(a *AccountData) { a.Attributes.Country = "invalid" }, "country in body should match '^[A-Z]{2}$'"},
+		{"invalid bic", func(a *AccountData) { a.Attributes.Bic = "WRONGBIC123213" }, "bic in body should match '^([A-Z]{6}[A-Z0-9]{2}|[A-Z]{6}[A-Z0-9]{5})$'"},
+		{"too many names", func(a *AccountData) { a.Attributes.Name = make([]string, MaxNames+1) }, "name in body should have at most 4 items"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// WHEN
+			data := valid()
+			tc.mutate(data)
+
+			// THEN
+			err := data.Validate()
+			if tc.expected == "" {
+				assert.Nil(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.expected)
+			var validationErr *ValidationError
+			assert.ErrorAs(t, err, &validationErr)
+		})
+	}
+}
+
+// Walking a list without links pages through until an empty page comes back
+func TestWalkAccountsPagesThroughTheList(t *testing.T) {
+	// WHEN
+	total := 7
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var number, size int
+		fmt.Sscan(r.URL.Query().Get("page[number]"), &number)
+		fmt.Sscan(r.URL.Query().Get("page[size]"), &size)
+		page := accountListBody{Data: []*AccountData{}}
+		for i := number * size; i < total && i < (number+1)*size; i++ {
+			page.Data = append(page.Data, &AccountData{ID: fmt.Sprint(i)})
+		}
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+
+	// THEN
+	var walked []string
+	err := client.WalkAccounts(context.Background(), 3, func(acc *AccountData) error {
+		walked = append(walked, acc.ID)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6"}, walked)
+}
+
+// A server serving fewer accounts than asked for is walked through its next links, not stopped at the first page
+func TestWalkAccountsFollowsTheNextLinks(t *testing.T) {
+	// WHEN
+	total, maxSize := 7, 2
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var number, size int
+		fmt.Sscan(r.URL.Query().Get("page[number]"), &number)
+		fmt.Sscan(r.URL.Query().Get("page[size]"), &size)
+		sizes = append(sizes, size)
+		if size > maxSize {
+			size = maxSize
+		}
+		link := func(n int) string {
+			return fmt.Sprintf("/v1/organisation/accounts?page%%5Bnumber%%5D=%d&page%%5Bsize%%5D=%d", n, size)
+		}
+		page := accountListBody{Data: []*AccountData{}, Links: &ListLinks{Self: link(number)}}
+		for i := number * size; i < total && i < (number+1)*size; i++ {
+			page.Data = append(page.Data, &AccountData{ID: fmt.Sprint(i)})
+		}
+		if (number+1)*size < total {
+			page.Links.Next = link(number + 1)
+		}
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+
+	// THEN
+	var walked []string
+	err := client.WalkAccounts(context.Background(), 5, func(acc *AccountData) error {
+		walked = append(walked, acc.ID)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6"}, walked)
+	assert.Equal(t, []int{5, 2, 2, 2}, sizes, "the pages after the first are asked for at the size the server serves")
+}
+
+// Bodies over the limit fail with a typed error, whether their size is announced or not, and are not retried
+func TestResponseSizeLimit(t *testing.T) {
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	account := `{"data": {"id": "` + id + `"}}`
+	cases := []struct {
+		name    string
+		status  int
+		body    string
+		chunked bool
+		limit   int64
+	}{
+		{"announced", 200, account, false, int64(len(account)) - 1},
+		{"chunked", 200, account, true, int64(len(account)) - 1},
+		{"error page", 502, "<html>" + strings.Repeat("<p>bad gateway</p>", 100) + "</html>", true, 100},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// WHEN
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if !tc.chunked {
+					w.Header().Set("Content-Length", fmt.Sprint(len(tc.body)))
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			client, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{MaxResponseSize: tc.limit})
+			require.NoError(t, err)
+
+			// THEN
+			_, err = client.GetById(context.Background(), id)
+			var tooLarge *ResponseTooLargeError
+			require.ErrorAs(t, err, &tooLarge)
+			assert.Equal(t, &ResponseTooLargeError{StatusCode: tc.status, Limit: tc.limit}, tooLarge)
+			assert.Equal(t, 1, attempts)
+		})
+	}
+
+	// a body of exactly the limit is read in full
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(account))
+	}))
+	defer server.Close()
+	client, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{MaxResponseSize: int64(len(account))})
+	require.NoError(t, err)
+	acc, err := client.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, acc.ID)
+}
+
+// Strict mode flags the responses that drifted from the models, the default lets them through
+func TestStrictMode(t *testing.T) {
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	fromTheAPI := `{"data": {"attributes": {"country": "GB"}, "created_on": "2021-08-29T10:15:56.187Z", "id": "` + id +
+		`", "modified_on": "2021-08-29T10:15:56.187Z", "type": "accounts", "version": 0}, "links": {"self": "/v1/organisation/accounts/` + id + `"}}`
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		strictErr   string
+	}{
+		{"as the API answers", "application/vnd.api+json", fromTheAPI, ""},
+		{"with a charset", "application/vnd.api+json; charset=utf-8", fromTheAPI, ""},
+		{"plain json", "application/json", fromTheAPI, `unexpected response: content type "application/json" instead of "application/vnd.api+json"`},
+		{"no content type", "", fromTheAPI, `unexpected response: content type "" instead of "application/vnd.api+json"`},
+		{"unknown field", "application/vnd.api+json", `{"data": {"id": "` + id + `", "status_reason": "unspecified"}}`, `unexpected response: json: unknown field "status_reason"`},
+	}
+	decoderErrors := map[string]string{"unknown field": `json: unknown field "status_reason"`}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// WHEN
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{tc.contentType} // set even when empty, so it is not sniffed
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			lenient := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+			strict, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{Strict: true})
+			require.NoError(t, err)
+
+			// THEN
+			acc, err := lenient.GetById(context.Background(), id)
+			assert.NoError(t, err)
+			assert.Equal(t, id, acc.ID)
+			acc, err = strict.GetById(context.Background(), id)
+			if tc.strictErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, id, acc.ID)
+				return
+			}
+			assert.EqualError(t, err, tc.strictErr)
+			assert.ErrorIs(t, err, ErrUnexpectedResponse)
+			if decoderErr, ok := decoderErrors[tc.name]; ok {
+				assert.EqualError(t, errors.Unwrap(err), decoderErr, "the decoder error is wrapped as it is")
+			}
+		})
+	}
+}
+
+// A body that is not JSON fails with the same error whether the client is strict or not
+func TestStrictModeDecodesLikeTheDefault(t *testing.T) {
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	for _, body := range []string{`{"data": {"id": "` + id + `"`, `{"data": [}`, `<html>bad gateway</html>`} {
+		// WHEN
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.api+json")
+			w.Write([]byte(body))
+		}))
+		lenient := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+		strict, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{Strict: true})
+		require.NoError(t, err)
+
+		// THEN
+		_, lenientErr := lenient.GetById(context.Background(), id)
+		_, strictErr := strict.GetById(context.Background(), id)
+		require.Error(t, lenientErr)
+		assert.EqualError(t, strictErr, lenientErr.Error(), body)
+		_, lenientErr = lenient.ListAccounts(context.Background(), 0, 1)
+		_, strictErr = strict.ListAccounts(context.Background(), 0, 1)
+		require.Error(t, lenientErr)
+		assert.EqualError(t, strictErr, lenientErr.Error(), body)
+		server.Close()
+	}
+}
+
+// An error body that is not JSON, like the HTML page of a gateway, shows up in the error by its first characters
+func TestNonJSONErrorBodies(t *testing.T) {
+	// WHEN
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(404)
+		fmt.Fprintf(w, "<html>\r\n<head><title>404 Not Found</title></head>\r\n<body>%s</body>\r\n</html>", strings.Repeat("<p>gone</p>", 20))
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+
+	// THEN
+	_, err := client.GetById(context.Background(), "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc")
+	var responseErr *ResponseError
+	require.ErrorAs(t, err, &responseErr)
+	assert.Equal(t, "non-JSON body: <html> <head><title>404 Not Found</title></head> <body><p>gone</p><p>gone</p><p>gone</p><p>gone</p><p>gone</p><p>gone</p...", responseErr.Message)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// Every status the default policy knows does what it is documented to do, and the unknown ones fail
+func TestDefaultStatusPolicy(t *testing.T) {
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	cases := []struct {
+		status   int
+		expected StatusAction
+	}{
+		{200, StatusSucceed}, {201, StatusSucceed}, {204, StatusSucceed},
+		{307, StatusRedirect}, {308, StatusRedirect},
+		{408, StatusRetry}, {429, StatusRetry}, {500, StatusRetry}, {502, StatusRetry}, {503, StatusRetry}, {504, StatusRetry},
+		{301, StatusFail}, {302, StatusFail}, {303, StatusFail}, {304, StatusFail},
+		{400, StatusFail}, {401, StatusFail}, {403, StatusFail}, {404, StatusFail}, {405, StatusFail}, {406, StatusFail},
+		{409, StatusFail}, {410, StatusFail}, {412, StatusFail}, {422, StatusFail}, {501, StatusFail}, {599, StatusFail},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(fmt.Sprint(tc.status), func(t *testing.T) {
+			t.Parallel()
+			// WHEN
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/moved" || atomic.AddInt32(&attempts, 1) > 1 {
+					w.Write([]byte(`{"data": {"id": "` + id + `"}}`))
+					return
+				}
+				w.Header().Set("Location", "/moved")
+				w.WriteHeader(tc.status)
+				if tc.status != 204 && tc.status != 304 {
+					w.Write([]byte(`{"error_message": "status ` + fmt.Sprint(tc.status) + `"}`))
+				}
+			}))
+			defer server.Close()
+			client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+
+			// THEN
+			acc, err := client.GetById(context.Background(), id)
+			switch tc.expected {
+			case StatusSucceed:
+				assert.NoError(t, err)
+			case StatusRedirect, StatusRetry:
+				require.NoError(t, err)
+				assert.Equal(t, id, acc.ID)
+			case StatusFail:
+				var responseErr *ResponseError
+				require.ErrorAs(t, err, &responseErr)
+				assert.Equal(t, tc.status, responseErr.StatusCode)
+				if tc.status != 304 {
+					assert.Equal(t, fmt.Sprintf("status %d", tc.status), responseErr.Message)
+				}
+			}
+			expectedAttempts := int32(1)
+			if tc.expected == StatusRetry {
+				expectedAttempts = 2
+			}
+			assert.Equal(t, expectedAttempts, atomic.LoadInt32(&attempts))
+			assert.Equal(t, tc.expected, DefaultStatusPolicy().action(tc.status))
+		})
+	}
+}
+
+// A redirect is sent with the method and the body of the request, the way 307 and 308 mean it
+func TestRedirectsPreserveTheMethod(t *testing.T) {
+	// WHEN
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	var redirected []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/maintenance") {
+			status := map[string]int{http.MethodPost: 307, http.MethodPatch: 308, http.MethodDelete: 302, http.MethodGet: 302}[r.Method]
+			w.Header().Set("Location", "/maintenance"+r.URL.RequestURI())
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		redirected = append(redirected, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(204)
+			return
+		}
+		w.WriteHeader(200)
+		w.Write(body)
+	}))
+	defer server.Close()
+	client, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{
+		StatusPolicy: StatusPolicy{http.StatusFound: StatusRedirect},
+	})
+	require.NoError(t, err)
+
+	// THEN
+	_, err = client.CreateAccount(context.Background(), &AccountData{ID: id})
+	assert.NoError(t, err)
+	_, err = client.PatchAccount(context.Background(), id, 1, &AccountPatch{})
+	assert.NoError(t, err)
+	assert.NoError(t, client.DeleteAccount(context.Background(), id, 1))
+	assert.Equal(t, []string{
+		`POST /maintenance/v1/organisation/accounts {"data":{"id":"` + id + `"}}`,
+		`PATCH /maintenance/v1/organisation/accounts/` + id + ` {"data":{"id":"` + id + `","type":"accounts","version":1,"attributes":{}}}`,
+		`DELETE /maintenance/v1/organisation/accounts/` + id + `?version=1 `,
+	}, redirected)
+
+	// the default client does not follow a 302 at all
+	_, err = newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout}).GetById(context.Background(), id)
+	var responseErr *ResponseError
+	require.ErrorAs(t, err, &responseErr)
+	assert.Equal(t, http.StatusFound, responseErr.StatusCode)
+	assert.Len(t, redirected, 3)
+}
+
+func TestStatusPolicyOverrides(t *testing.T) {
+	// WHEN
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		default:
+			w.Header().Set("Location", r.URL.Path)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		}
+	}))
+	defer server.Close()
+	client, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{
+		StatusPolicy: StatusPolicy{http.StatusUnprocessableEntity: StatusRetry},
+	})
+	require.NoError(t, err)
+
+	// THEN
+	_, err = client.GetById(context.Background(), "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc")
+	var retryErr *RetryError
+	require.ErrorAs(t, err, &retryErr)
+	assert.EqualError(t, retryErr.Err, "stopped after 10 redirects, the last one with status code 307")
+	require.Len(t, retryErr.Attempts, 2)
+	assert.Equal(t, http.StatusUnprocessableEntity, retryErr.Attempts[0].StatusCode)
+	assert.Equal(t, int32(12), atomic.LoadInt32(&attempts), "a retry and then 11 redirects")
+	assert.Equal(t, StatusSucceed, DefaultStatusPolicy()[200])
+
+	_, err = NewAccountClientWithOptions(server.URL, &http.Client{}, ClientOptions{StatusPolicy: StatusPolicy{200: "ignore"}})
+	assert.EqualError(t, err, `invalid status policy: unknown action "ignore" for 200`)
+	_, err = NewAccountClientWithOptions(server.URL, &http.Client{}, ClientOptions{StatusPolicy: StatusPolicy{42: StatusFail}})
+	assert.EqualError(t, err, "invalid status policy: 42 is not a status code")
+}
+
+// An attempt that runs out of the attempt timeout is retried, within the operation timeout
+func TestAttemptTimeoutIsRetried(t *testing.T) {
+	// WHEN
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte(`{"data": {"id": "` + id + `"}}`))
+	}))
+	defer server.Close()
+	client, err := NewAccountClientWithOptions(server.URL, &http.Client{}, ClientOptions{
+		AttemptTimeout: 100 * time.Millisecond, OperationTimeout: 2 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// THEN
+	acc, err := client.GetById(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, id, acc.ID)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+// The call gives up as soon as what is left of its budget cannot fit a backoff and another attempt, and the
+// error tells which budget ran out
+func TestOperationTimeoutSkipsHopelessRetries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	cases := []struct {
+		name     string
+		opts     ClientOptions
+		deadline time.Duration
+		budget   Budget
+		expected string
+		within   time.Duration
+	}{
+		{"no room for a backoff and an attempt", ClientOptions{AttemptTimeout: 300 * time.Millisecond, OperationTimeout: time.Second}, 0,
+			BudgetOperation, "get ran out of the operation timeout of 1s: context deadline exceeded", 500 * time.Millisecond},
+		{"ran out during an attempt", ClientOptions{OperationTimeout: 100 * time.Millisecond}, 0,
+			BudgetOperation, "get ran out of the operation timeout of 100ms: context deadline exceeded", 300 * time.Millisecond},
+		{"timeout of the operation", ClientOptions{OperationTimeout: 10 * time.Second, Timeouts: map[Operation]Timeouts{OperationGet: {Operation: 100 * time.Millisecond}}}, 0,
+			BudgetOperation, "get ran out of the operation timeout of 100ms: context deadline exceeded", 300 * time.Millisecond},
+		{"caller's deadline first", ClientOptions{OperationTimeout: 10 * time.Second}, 100 * time.Millisecond,
+			BudgetContext, "get ran out of the context deadline: context deadline exceeded", 300 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// WHEN
+			atomic.StoreInt32(&attempts, 0)
+			client, err := NewAccountClientWithOptions(server.URL, &http.Client{}, tc.opts)
+			require.NoError(t, err)
+			ctx := context.Background()
+			if tc.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.deadline)
+				defer cancel()
+			}
+
+			// THEN
+			start := time.Now()
+			_, err = client.GetById(ctx, id)
+			assert.Less(t, time.Since(start), tc.within)
+			var retryErr *RetryError
+			if errors.As(err, &retryErr) { // the attempt timed out and was going to be retried
+				assert.EqualError(t, retryErr.Attempts[0].Err, "get ran out of the attempt timeout of 300ms: "+
+					`Get "`+server.URL+"/v1/organisation/accounts/"+id+`": context deadline exceeded`)
+				err = retryErr.Err
+			}
+			assert.EqualError(t, err, tc.expected)
+			var timeoutErr *TimeoutError
+			require.ErrorAs(t, err, &timeoutErr)
+			assert.Equal(t, tc.budget, timeoutErr.Budget)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+		})
+	}
+
+	_, err := NewAccountClientWithOptions(server.URL, &http.Client{}, ClientOptions{Timeouts: map[Operation]Timeouts{"merge": {}}})
+	assert.EqualError(t, err, `invalid timeouts: unknown operation "merge"`)
+	_, err = NewAccountClientWithOptions(server.URL, &http.Client{}, ClientOptions{AttemptTimeout: -time.Second})
+	assert.EqualError(t, err, "invalid timeouts: they cannot be negative")
+}
+
+// A call that was retried reports every attempt and what the server said, and still matches its last cause
+func TestRetryHistory(t *testing.T) {
+	// WHEN
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			w.WriteHeader(503)
+			w.Write([]byte(`{"error_message": "database is down"}`))
+		case 2:
+			w.WriteHeader(502)
+			w.Write([]byte(`<html><body>Bad Gateway</body></html>`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error_message": "record does not exist"}`))
+		}
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout})
+
+	// THEN
+	_, err := client.GetById(context.Background(), "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc")
+	var retryErr *RetryError
+	require.ErrorAs(t, err, &retryErr)
+	require.Len(t, retryErr.Attempts, 3)
+	statuses, messages := []int{}, []string{}
+	for _, a := range retryErr.Attempts {
+		statuses = append(statuses, a.StatusCode)
+		messages = append(messages, a.Err.Error())
+		assert.Greater(t, a.Duration, time.Duration(0))
+	}
+	assert.Equal(t, []int{503, 502, 404}, statuses)
+	assert.Equal(t, []string{"database is down", "non-JSON body: <html><body>Bad Gateway</body></html>",
+		"response status code 404 with error message: record does not exist"}, messages)
+	assert.InDelta(t, 500*time.Millisecond, retryErr.Attempts[0].Backoff, float64(50*time.Millisecond))
+	assert.InDelta(t, 500*time.Millisecond, retryErr.Attempts[1].Backoff, float64(50*time.Millisecond))
+	assert.Zero(t, retryErr.Attempts[2].Backoff)
+	assert.ErrorIs(t, err, ErrNotFound)
+	var responseErr *ResponseError
+	require.ErrorAs(t, err, &responseErr)
+	assert.Equal(t, "record does not exist", responseErr.Message)
+	assert.Regexp(t, `^response status code 404 with error message: record does not exist; attempts: `+
+		`#1 status 503 "database is down" in \d+m?s, then \d+ms backoff; `+
+		`#2 status 502 "non-JSON body: <html><body>Bad Gateway</body></html>" in \d+m?s, then \d+ms backoff; `+
+		`#3 status 404 "response status code 404 with error message: record does not exist" in \d+m?s$`, err.Error())
+
+	// a single attempt that is not retried is returned as it is
+	_, err = client.GetById(context.Background(), "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc")
+	assert.EqualError(t, err, "response status code 404 with error message: record does not exist")
+}
+
+// The hooks get the events of every attempt in order, and Close waits for them to be delivered
+func TestHooks(t *testing.T) {
+	// WHEN
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			w.WriteHeader(503)
+			w.Write([]byte(`{"error_message": "database is down"}`))
+		case 2:
+			w.Write([]byte(`{"data": {"id": "` + id + `"}}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error_message": "record does not exist"}`))
+		}
+	}))
+	defer server.Close()
+	var events []string
+	var backoff time.Duration
+	client, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{Hooks: Hooks{
+		OnRequest: func(e RequestEvent) {
+			events = append(events, fmt.Sprintf("request %s #%d %s %s", e.Operation, e.Attempt, e.Method, strings.TrimPrefix(e.URL, server.URL)))
+		},
+		OnResponse: func(e ResponseEvent) {
+			assert.Greater(t, e.Latency, time.Duration(0))
+			events = append(events, fmt.Sprintf("response %s #%d %d %v", e.Operation, e.Attempt, e.StatusCode, e.Err))
+		},
+		OnRetry: func(e RetryEvent) {
+			backoff = e.Backoff
+			events = append(events, fmt.Sprintf("retry %s #%d %d %v", e.Operation, e.Attempt, e.StatusCode, e.Err))
+		},
+		OnGiveUp: func(e GiveUpEvent) {
+			events = append(events, fmt.Sprintf("give up %s after %d %d %v", e.Operation, e.Attempts, e.StatusCode, e.Err))
+		},
+	}})
+	require.NoError(t, err)
+
+	// THEN
+	_, err = client.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	_, err = client.GetById(context.Background(), id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, client.Close())
+	assert.Equal(t, []string{
+		"request get #1 GET /v1/organisation/accounts/" + id,
+		"response get #1 503 database is down",
+		"retry get #1 503 database is down",
+		"request get #2 GET /v1/organisation/accounts/" + id,
+		"response get #2 200 <nil>",
+		"request get #1 GET /v1/organisation/accounts/" + id,
+		"response get #1 404 response status code 404 with error message: record does not exist",
+		"give up get after 1 404 response status code 404 with error message: record does not exist",
+	}, events)
+	assert.InDelta(t, 500*time.Millisecond, backoff, float64(50*time.Millisecond))
+	assert.Equal(t, int64(0), client.DroppedEvents())
+
+	// after Close the calls still work and their events are dropped
+	_, err = client.GetById(context.Background(), id)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int64(3), client.DroppedEvents())
+	assert.Len(t, events, 8)
+}
+
+// A slow or panicking hook never holds a call back; the events that do not fit the queue are dropped
+func TestHooksNeverBlockTheCalls(t *testing.T) {
+	// WHEN
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"id": "` + id + `"}}`))
+	}))
+	defer server.Close()
+	entered, release := make(chan struct{}), make(chan struct{})
+	var requests int32
+	client, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{Hooks: Hooks{
+		OnRequest: func(RequestEvent) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				close(entered)
+				<-release
+			}
+			panic("telemetry is down")
+		},
+		QueueSize: 2,
+	}})
+	require.NoError(t, err)
+
+	// THEN
+	_, err = client.GetById(context.Background(), id)
+	require.NoError(t, err)
+	<-entered
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err = client.GetById(context.Background(), id)
+		require.NoError(t, err)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int64(2), client.DroppedEvents())
+	close(release)
+	assert.NoError(t, client.Close())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "the hook still gets the queued events after a panic")
+	assert.NoError(t, newTestClient(t, server.URL, &http.Client{}).Close(), "a client without hooks has nothing to close")
+}
+
+// The hooks' goroutine stops once the events are delivered, so a client that is never closed leaves nothing running
+func TestHooksStopWhenIdle(t *testing.T) {
+	// WHEN
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"id": "` + id + `"}}`))
+	}))
+	defer server.Close()
+	var delivered int32
+	client, err := NewAccountClientWithOptions(server.URL, &http.Client{Timeout: ClientTimeout}, ClientOptions{Hooks: Hooks{
+		OnResponse: func(ResponseEvent) { atomic.AddInt32(&delivered, 1) },
+	}})
+	require.NoError(t, err)
+	running := func() bool {
+		client.events.mu.Lock()
+		defer client.events.mu.Unlock()
+		return client.events.running
+	}
+
+	// THEN
+	assert.False(t, running(), "nothing runs before the first event")
+	for round := int32(1); round <= 2; round++ {
+		for i := 0; i < 3; i++ {
+			_, err = client.GetById(context.Background(), id)
+			require.NoError(t, err)
+		}
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&delivered) == 3*round && !running() },
+			time.Second, time.Millisecond, "the goroutine delivers the events and stops")
+	}
+}
+
+// drainRecorder tells whether every response body was read to the end before it was closed; older transports
+// only reuse a connection when it was
+type drainRecorder struct {
+	undrained int32
+}
+
+type recordedBody struct {
+	io.ReadCloser
+	recorder *drainRecorder
+	eof      bool
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.eof = b.eof || err == io.EOF
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	if !b.eof {
+		atomic.AddInt32(&b.recorder.undrained, 1)
+	}
+	return b.ReadCloser.Close()
+}
+
+func (d *drainRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if err == nil {
+		resp.Body = &recordedBody{ReadCloser: resp.Body, recorder: d}
+	}
+	return resp, err
+}
+
+// What the client leaves of a body is drained, so that keep-alive connections get reused
+func TestResponseBodiesAreDrained(t *testing.T) {
+	// WHEN
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			w.Write([]byte(`{"unused": true}`)) // nothing decodes this one
+		case http.MethodPost:
+			w.WriteHeader(409)
+			w.Write([]byte(`{"error_message": "duplicate"}`))
+		default:
+			json.NewEncoder(w).Encode(accountBody{Data: &AccountData{ID: id}}) // the decoder stops before the newline
+		}
+	}))
+	defer server.Close()
+	recorder := &drainRecorder{}
+	client := newTestClient(t, server.URL, &http.Client{Timeout: ClientTimeout, Transport: recorder})
+
+	// THEN
+	_, err := client.GetById(context.Background(), id)
+	assert.NoError(t, err)
+	assert.NoError(t, client.DeleteAccount(context.Background(), id, 0))
+	_, err = client.CreateAccount(context.Background(), &AccountData{ID: id})
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&recorder.undrained))
+}
+
+// cannedTransport answers every request in process and tracks how many goroutines run while it does, so a
+// benchmark measures the client rather than the network
+type cannedTransport struct {
+	body     []byte
+	baseline int
+	extra    int
+}
+
+func (c *cannedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if extra := runtime.NumGoroutine() - c.baseline; extra > c.extra {
+		c.extra = extra
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"application/vnd.api+json"}},
+		Body:       io.NopCloser(bytes.NewReader(c.body)),
+		Request:    r,
+	}, nil
+}
+
+// go test -tags unit -run '^$' -bench GetById -benchmem ./account
+func BenchmarkGetById(b *testing.B) {
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	transport := &cannedTransport{body: []byte(`{"data": {"id": "` + id + `", "type": "accounts", "version": 0}}`)}
+	client, err := NewAccountClient("http://api.test", &http.Client{Transport: transport})
+	require.NoError(b, err)
+	ctx := context.Background()
+	transport.baseline = runtime.NumGoroutine()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.GetById(ctx, id); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(transport.extra), "extra-goroutines/op")
+}
+
+// The same over a loopback connection, closer to what the read path pays in production
+func BenchmarkGetByIdHTTP(b *testing.B) {
+	id := "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"id": "` + id + `", "type": "accounts", "version": 0}}`))
+	}))
+	defer server.Close()
+	client, err := NewAccountClient(server.URL, &http.Client{Timeout: ClientTimeout})
+	require.NoError(b, err)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.GetById(ctx, id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
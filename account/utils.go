@@ -1,6 +1,7 @@
 package account
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,11 +9,14 @@ import (
 	"io"
 	"math"
 	"math/rand"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strings"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 type RetryOnError struct {
@@ -24,93 +28,485 @@ func (e RetryOnError) Error() string {
 	return fmt.Sprintf("Response status code: %v; with error: %s", e.s, e.err)
 }
 
-// handleRequest deals with retries and is controlled by the parent context
-func handleRequest(ctx context.Context, resultChan chan *processedResult, client *http.Client, request *http.Request) {
-	var retryErr RetryOnError
-	retries := 0
-	for {
-		select { // exit early if context is cancelled
-		case <-ctx.Done():
-			break
-		default:
+var (
+	ErrNotFound = errors.New("account not found")
+	ErrConflict = errors.New("account version conflict")
+	// ErrUnexpectedResponse is a successful response the client cannot use, such as one that strict mode rejects
+	ErrUnexpectedResponse = errors.New("unexpected response")
+)
+
+// unexpectedResponseError is a body strict mode rejects, matching ErrUnexpectedResponse and wrapping the decoder
+// error
+type unexpectedResponseError struct {
+	err error
+}
+
+func (e *unexpectedResponseError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnexpectedResponse, e.err)
+}
+
+func (e *unexpectedResponseError) Is(target error) bool {
+	return target == ErrUnexpectedResponse
+}
+
+func (e *unexpectedResponseError) Unwrap() error {
+	return e.err
+}
+
+// ResponseError is a response the server answered with a non retryable error status. When the body is not JSON,
+// such as the HTML page of a gateway, Message is "non-JSON body: " followed by the first characters of it.
+type ResponseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("response status code %d with error message: %s", e.StatusCode, e.Message)
+}
+
+// Is lets callers classify failures with errors.Is(err, ErrNotFound) and errors.Is(err, ErrConflict)
+func (e *ResponseError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// ResponseTooLargeError is a response whose body is over ClientOptions.MaxResponseSize; it is not retried
+type ResponseTooLargeError struct {
+	StatusCode int
+	Limit      int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body with status code %d exceeds the limit of %d bytes", e.StatusCode, e.Limit)
+}
+
+// maxSnippet is how much of an error body that is not JSON goes into the error message
+const maxSnippet = 120
+
+// maxDrain is what is read of an unused response body so that its connection can be reused; past that, dialing
+// again is cheaper than reading on
+const maxDrain = 64 << 10
+
+// Budget names the time budget that ended a call
+type Budget string
+
+const (
+	BudgetAttempt   Budget = "attempt"   // ClientOptions.AttemptTimeout
+	BudgetOperation Budget = "operation" // ClientOptions.OperationTimeout
+	BudgetContext   Budget = "context"   // the deadline of the caller's context
+)
+
+// TimeoutError is a call or an attempt that ran out of time; it unwraps to context.DeadlineExceeded, or to the
+// error of the attempt that timed out
+type TimeoutError struct {
+	Operation Operation
+	Budget    Budget
+	Timeout   time.Duration // not set for the caller's context
+	Err       error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Budget == BudgetContext {
+		return fmt.Sprintf("%s ran out of the context deadline: %s", e.Operation, e.Err)
+	}
+	return fmt.Sprintf("%s ran out of the %s timeout of %v: %s", e.Operation, e.Budget, e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Attempt is one request of a call that was retried
+type Attempt struct {
+	StatusCode int // 0 when no response came back
+	Err        error
+	Duration   time.Duration
+	Backoff    time.Duration // slept after the attempt, 0 when it was the last one
+}
+
+// RetryError is the error of a call that was retried: it lists every attempt in order, with what the server
+// answered, and unwraps to the error the call ended with
+type RetryError struct {
+	Attempts []Attempt
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s; attempts:", e.Err)
+	for i, a := range e.Attempts {
+		if i > 0 {
+			b.WriteString(";")
+		}
+		fmt.Fprintf(&b, " #%d", i+1)
+		if a.StatusCode != 0 {
+			fmt.Fprintf(&b, " status %d", a.StatusCode)
+		}
+		if a.Err != nil && a.Err.Error() != "" {
+			fmt.Fprintf(&b, " %q", a.Err.Error())
+		}
+		fmt.Fprintf(&b, " in %v", a.Duration.Round(time.Millisecond))
+		if a.Backoff > 0 {
+			fmt.Fprintf(&b, ", then %v backoff", a.Backoff.Round(time.Millisecond))
+		}
+	}
+	return b.String()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// attemptOf records an attempt, with the cause of a retry rather than the RetryOnError
+func attemptOf(status int, err error, duration time.Duration) Attempt {
+	if retryErr, ok := err.(RetryOnError); ok {
+		err = retryErr.err
+	}
+	return Attempt{StatusCode: status, Err: err, Duration: duration}
+}
+
+// handleRequest sends the request until it gets an answer that is not retried. The context bounds the whole
+// exchange: the transport aborts the attempt in flight and the backoff is cut short when it ends, and no backoff
+// is slept when what is left of the budget could not fit another attempt.
+func (ac *AccountClient) handleRequest(ctx context.Context, op Operation, request *http.Request, out interface{}) error {
+	timeouts := ac.options.timeouts(op)
+	opCtx := ctx
+	if timeouts.Operation > 0 {
+		var cancel context.CancelFunc
+		opCtx, cancel = context.WithTimeout(ctx, timeouts.Operation)
+		defer cancel()
+	}
+	// ended tells which of the contexts is over, if any
+	ended := func() error {
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			return &TimeoutError{op, BudgetContext, 0, ctx.Err()}
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case opCtx.Err() != nil:
+			return &TimeoutError{op, BudgetOperation, timeouts.Operation, opCtx.Err()}
 		}
+		return nil
+	}
 
-		err := handleRequestOnce(ctx, resultChan, client, request)
+	var history []Attempt
+	retried := false
+	start := time.Now()
+	// giveUp adds the attempts to the error once any of them was going to be retried
+	giveUp := func(err error) error {
+		if ac.events != nil {
+			last := history[len(history)-1]
+			ac.events.emit(GiveUpEvent{op, len(history), last.StatusCode, time.Since(start), err})
+		}
+		if !retried {
+			return err
+		}
+		return &RetryError{Attempts: history, Err: err}
+	}
+	if opCtx != ctx {
+		request = request.WithContext(opCtx)
+	}
+	attempt := request
+	for retries := 0; ; retries++ {
+		number := retries + 1
+		if ac.events != nil {
+			ac.events.emit(RequestEvent{op, number, attempt.Method, attempt.URL.String()})
+		}
+		attemptStart := time.Now()
+		status, err := ac.attempt(op, timeouts.Attempt, attempt, out)
+		record := attemptOf(status, err, time.Since(attemptStart))
+		if ac.events != nil {
+			ac.events.emit(ResponseEvent{op, number, status, record.Duration, record.Err})
+		}
+		if err == nil {
+			return nil
+		}
+		history = append(history, record)
+		if budgetErr := ended(); budgetErr != nil {
+			return giveUp(budgetErr) // whatever the attempt failed with, the caller or the operation gave up first
+		}
+		var retryErr RetryOnError
 		if !errors.As(err, &retryErr) {
-			break
+			return giveUp(err)
 		}
+		retried = true
 
 		noise := rand.Int()%100 - 50
 		backoff := int(math.Pow(1.5, float64(retries)))*500 + noise
 		after := time.Duration(backoff) * time.Millisecond
-		log.Ctx(ctx).Info().Str("endpoint", request.URL.Path).Msg(fmt.Sprintf("Retrying in %v", after))
-		time.Sleep(after)
-		retries++
+		if deadline, ok := opCtx.Deadline(); ok && time.Until(deadline) < after+timeouts.Attempt {
+			ac.logger.Info().Str("endpoint", request.URL.Path).Msg("Not retrying, no time is left for another attempt")
+			budget := &TimeoutError{op, BudgetOperation, timeouts.Operation, context.DeadlineExceeded}
+			if callerDeadline, ok := ctx.Deadline(); ok && !callerDeadline.After(deadline) {
+				budget.Budget, budget.Timeout = BudgetContext, 0
+			}
+			return giveUp(budget)
+		}
+		ac.logger.Info().Str("endpoint", request.URL.Path).Msg(fmt.Sprintf("Retrying in %v", after))
+		if ac.events != nil {
+			ac.events.emit(RetryEvent{op, number, status, after, record.Err})
+		}
+		sleep := time.Now()
+		timer := time.NewTimer(after)
+		select {
+		case <-opCtx.Done():
+			timer.Stop()
+			history[len(history)-1].Backoff = time.Since(sleep)
+			return giveUp(ended())
+		case <-timer.C:
+		}
+		history[len(history)-1].Backoff = after
+		if attempt, err = rewind(request); err != nil {
+			return giveUp(err)
+		}
+	}
+}
+
+// attempt runs handleRequestOnce within the attempt timeout; running out of it is retried
+func (ac *AccountClient) attempt(op Operation, timeout time.Duration, request *http.Request, out interface{}) (int, error) {
+	if timeout <= 0 {
+		return ac.handleRequestOnce(request, out)
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	defer cancel() // only once the body is read
+	status, err := ac.handleRequestOnce(request.WithContext(ctx), out)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && request.Context().Err() == nil {
+		if retryErr, ok := err.(RetryOnError); ok {
+			err = retryErr.err
+		}
+		return status, RetryOnError{status, &TimeoutError{op, BudgetAttempt, timeout, err}}
+	}
+	return status, err
+}
+
+// rewind copies the request with a fresh body, since the attempt before drained it
+func rewind(request *http.Request) (*http.Request, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return request, nil
+	}
+	if request.GetBody == nil {
+		return nil, errors.New("cannot retry the request, its body cannot be read again")
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("cannot retry the request: %w", err)
 	}
+	attempt := request.Clone(request.Context())
+	attempt.Body = body
+	return attempt, nil
 }
 
-// handleRequestOnce and return an error whether the request should be retried
-func handleRequestOnce(ctx context.Context, resultChan chan *processedResult, client *http.Client, request *http.Request) error {
-	body, statusCode, err := doAndReadBody(client, request)
-	var errorString string
+// handleRequestOnce makes one attempt and returns the status code it was answered with, 0 when there was no
+// response; a RetryOnError tells that the request should be sent again
+func (ac *AccountClient) handleRequestOnce(request *http.Request, out interface{}) (int, error) {
+	resp, err := ac.follow(request)
 	if err != nil {
 		if urlErr, ok := err.(*url.Error); ok {
-			errorString = urlErr.Error()
-			log.Ctx(ctx).Error().Str("type", "RequestError").Bool("timeout", urlErr.Timeout()).Str("endpoint", urlErr.URL).Msg(errorString)
-			return RetryOnError{statusCode, urlErr}
-		} else {
-			errorString = err.Error()
-			log.Ctx(ctx).Error().Str("type", "ReadError").Msg(errorString)
-			resultChan <- &processedResult{nil, fmt.Errorf("got an error while reading the response body: %w", err)}
-			return nil
+			ac.logger.Error().Str("type", "RequestError").Bool("timeout", urlErr.Timeout()).Str("endpoint", urlErr.URL).Msg(urlErr.Error())
+			return 0, RetryOnError{0, urlErr}
 		}
+		return 0, err
 	}
+	defer drainAndClose(resp.Body)
+	return resp.StatusCode, ac.handleResponse(request, resp, out)
+}
 
-	var deserializedOk createOkBody
-	var deserializedNotOk createErrorBody
+// handleResponse classifies the response by its status and decodes its body
+func (ac *AccountClient) handleResponse(request *http.Request, resp *http.Response, out interface{}) error {
+	statusCode := resp.StatusCode
+	limit := ac.options.MaxResponseSize
+	if resp.ContentLength > limit {
+		return &ResponseTooLargeError{statusCode, limit} // not a byte of it is read
+	}
+	body := &limitedBody{r: resp.Body, left: limit + 1, statusCode: statusCode, limit: limit}
 
-	switch statusCode {
-	case 204: // can receive this on DELETE
-		resultChan <- &processedResult{nil, nil}
-		return nil
-	case 200, 201:
-		{
-			err := json.Unmarshal(body, &deserializedOk)
-			if err != nil {
-				resultChan <- &processedResult{nil, fmt.Errorf("unable to deserialize response body; error: %w", err)}
-			}
-			resultChan <- &processedResult{deserializedOk.Data, nil}
+	switch ac.statuses.action(statusCode) {
+	case StatusSucceed:
+		if out == nil || statusCode == http.StatusNoContent {
 			return nil
 		}
-	case 400, 401, 403, 404, 405, 406, 409:
-		{
-			json.Unmarshal(body, &deserializedNotOk)
-			resultChan <- &processedResult{nil, fmt.Errorf("response status code %d with error message: %s", statusCode, deserializedNotOk.ErrorMessage)}
-			return nil
+		if ac.options.Strict {
+			if err := checkContentType(resp.Header.Get("Content-Type"), ac.contentType); err != nil {
+				return err
+			}
+		}
+		if err := ac.decode(body, out); err != nil {
+			if errors.Is(err, ErrUnexpectedResponse) {
+				return err
+			}
+			return body.failure(&ac.logger, fmt.Errorf("unable to deserialize response body; error: %w", err))
+		}
+		return nil
+	case StatusRetry:
+		var deserializedNotOk createErrorBody
+		if err := body.readError(&deserializedNotOk); err != nil {
+			return body.failure(&ac.logger, err)
 		}
-	case 429, 500, 502, 503, 504:
-		json.Unmarshal(body, &deserializedNotOk)
-		log.Ctx(ctx).Error().Str("type", "ResponseError").Int("responseStatus", statusCode).Str("endpoint", request.URL.Path).Msg(deserializedNotOk.ErrorMessage)
+		ac.logger.Error().Str("type", "ResponseError").Int("responseStatus", statusCode).Str("endpoint", request.URL.Path).Msg(deserializedNotOk.ErrorMessage)
 		return RetryOnError{statusCode, errors.New(deserializedNotOk.ErrorMessage)}
+	case StatusRedirect: // follow gave up on the redirects
+		return fmt.Errorf("stopped after %d redirects, the last one with status code %d", maxRedirects, statusCode)
 	default:
-		{ // what if the server starts redirecting ?
-			resultChan <- &processedResult{nil, fmt.Errorf("unexpected response status code: %d", statusCode)}
-			return nil
+		var deserializedNotOk createErrorBody
+		if err := body.readError(&deserializedNotOk); err != nil {
+			return body.failure(&ac.logger, err)
+		}
+		return &ResponseError{statusCode, deserializedNotOk.ErrorMessage}
+	}
+}
+
+// decode decodes a page of accounts as it arrives, so that it is never held as raw bytes too; a single account
+// is small, and is cheaper to read whole and unmarshal. Strict mode decodes the same way and checks the fields
+// afterwards, so that a body that is not JSON fails alike in both modes.
+func (ac *AccountClient) decode(body *limitedBody, out interface{}) error {
+	if _, page := out.(*accountListBody); !page {
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(content, out); err != nil {
+			return err
+		}
+		return ac.checkFields(content, out)
+	}
+	var input io.Reader = body
+	var seen bytes.Buffer
+	if ac.options.Strict {
+		input = io.TeeReader(body, &seen)
+	}
+	if err := json.NewDecoder(input).Decode(out); err != nil {
+		return err
+	}
+	return ac.checkFields(seen.Bytes(), out)
+}
+
+// checkFields decodes a body that already decoded into out once more in strict mode, into a value of the same type
+// that rejects the fields the models do not know
+func (ac *AccountClient) checkFields(content []byte, out interface{}) error {
+	if !ac.options.Strict {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(reflect.New(reflect.TypeOf(out).Elem()).Interface()); err != nil {
+		return &unexpectedResponseError{err}
+	}
+	return nil
+}
+
+// follow sends the request and the redirects of the status policy, each with the method and the body of the
+// request; the http client is set up to hand every redirect over rather than follow it
+func (ac *AccountClient) follow(request *http.Request) (*http.Response, error) {
+	for redirects := 0; ; redirects++ {
+		resp, err := ac.httpClient.Do(request)
+		if err != nil || ac.statuses.action(resp.StatusCode) != StatusRedirect || redirects == maxRedirects {
+			return resp, err
+		}
+		location, err := resp.Location()
+		drainAndClose(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot follow the redirect with status code %d: %w", resp.StatusCode, err)
+		}
+		ac.logger.Info().Str("endpoint", request.URL.Path).Int("responseStatus", resp.StatusCode).Msg("Redirected to " + location.String())
+		if request, err = redirect(request, location); err != nil {
+			return nil, err
 		}
 	}
 }
 
-func doAndReadBody(client *http.Client, request *http.Request) ([]byte, int, error) {
-	resp, err := client.Do(request) // when the response is not nil these may be caused by redirects only;
-	// and from the API docs, the server doesn't redirect
+// redirect copies the request to another location, with a fresh body
+func redirect(request *http.Request, location *url.URL) (*http.Request, error) {
+	next, err := rewind(request)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	if next == request {
+		next = request.Clone(request.Context())
+	}
+	next.URL = location
+	next.Host = ""
+	return next, nil
+}
+
+// limitedBody reads a response body up to a limit and remembers why reading it stopped short
+type limitedBody struct {
+	r          io.Reader
+	left       int64 // one more than the limit, so that a body of exactly the limit is not mistaken for a larger one
+	statusCode int
+	limit      int64
+	err        error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
 	}
-	defer resp.Body.Close()
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	if b.left == 0 {
+		n, err = n-1, &ResponseTooLargeError{b.statusCode, b.limit}
+	}
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
 
-	body, err := io.ReadAll(resp.Body)
+// readError reads an error body; when it is not JSON, e.g. the HTML page of a gateway, the start of it is
+// the message
+func (b *limitedBody) readError(into *createErrorBody) error {
+	content, err := io.ReadAll(b)
 	if err != nil {
-		return nil, 0, err
+		return err
+	}
+	if len(content) > 0 && json.Unmarshal(content, into) != nil {
+		into.ErrorMessage = "non-JSON body: " + snippet(content)
+	}
+	return nil
+}
+
+// snippet is the start of a body on one line
+func snippet(content []byte) string {
+	text := []rune(strings.Join(strings.Fields(string(content)), " "))
+	if len(text) > maxSnippet {
+		return string(text[:maxSnippet]) + "..."
 	}
-	return body, resp.StatusCode, nil
+	return string(text)
+}
+
+// checkContentType accepts the expected media type with any parameters, e.g. a charset
+func checkContentType(header, expected string) error {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil || mediaType != expected {
+		return fmt.Errorf("%w: content type %q instead of %q", ErrUnexpectedResponse, header, expected)
+	}
+	return nil
+}
+
+// failure tells a body that could not be read, or was too large, apart from one that could not be decoded
+func (b *limitedBody) failure(logger *zerolog.Logger, decodeErr error) error {
+	var tooLarge *ResponseTooLargeError
+	switch {
+	case errors.As(b.err, &tooLarge):
+		logger.Error().Str("type", "ResponseTooLarge").Int64("limit", tooLarge.Limit).Msg(tooLarge.Error())
+		return tooLarge
+	case b.err != nil:
+		logger.Error().Str("type", "ReadError").Msg(b.err.Error())
+		return fmt.Errorf("got an error while reading the response body: %w", b.err)
+	}
+	return decodeErr
+}
+
+// drainAndClose reads what is left of a body, up to maxDrain, so that the transport can reuse the connection
+func drainAndClose(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, maxDrain)
+	body.Close()
 }
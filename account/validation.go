@@ -0,0 +1,125 @@
+package account
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The patterns below are the ones the API reports in its validation messages
+var (
+	countryPattern    = regexp.MustCompile(`^[A-Z]{2}$`)
+	bankIDCodePattern = regexp.MustCompile(`^[A-Z]{0,16}$`)
+	bicPattern        = regexp.MustCompile(`^([A-Z]{6}[A-Z0-9]{2}|[A-Z]{6}[A-Z0-9]{5})$`)
+	currencyPattern   = regexp.MustCompile(`^[A-Z]{3}$`)
+	ibanPattern       = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{0,64}$`)
+	accountNoPattern  = regexp.MustCompile(`^[A-Z0-9]{0,64}$`)
+)
+
+const (
+	maxAlternativeNames = 3
+	maxNameLength       = 140
+)
+
+var (
+	accountTypes           = []string{"accounts"}
+	accountClassifications = []string{"Personal", "Business"}
+)
+
+// ValidationError is the first rule of the API that an account breaks; the message reads like the one the
+// server would send back
+type ValidationError struct {
+	Field     string // json name of the field
+	Reason    string
+	Attribute bool // the field sits under the account attributes
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s in body %s", e.Field, e.Reason)
+}
+
+// Validate checks the account against the API rules without a round trip to the server
+func (a *AccountData) Validate() error {
+	if err := checkUUID("id", a.ID); err != nil {
+		return err
+	}
+	if err := checkUUID("organisation_id", a.OrganisationID); err != nil {
+		return err
+	}
+	if err := checkEnum("type", a.Type, accountTypes, false); err != nil {
+		return err
+	}
+	if a.Version < 0 {
+		return &ValidationError{Field: "version", Reason: "should be greater than or equal to 0"}
+	}
+	if a.Attributes == nil {
+		return &ValidationError{Field: "attributes", Reason: "is required"}
+	}
+	return a.Attributes.validate()
+}
+
+func (at *AccountAttributes) validate() error {
+	if at.Country == "" {
+		return &ValidationError{Field: "country", Reason: "is required", Attribute: true}
+	}
+	patterns := []struct {
+		field   string
+		value   string
+		pattern *regexp.Regexp
+	}{
+		{"country", at.Country, countryPattern},
+		{"bank_id_code", at.BankIDCode, bankIDCodePattern},
+		{"bic", at.Bic, bicPattern},
+		{"base_currency", at.BaseCurrency, currencyPattern},
+		{"iban", at.Iban, ibanPattern},
+		{"account_number", at.AccountNumber, accountNoPattern},
+	}
+	for _, p := range patterns {
+		if p.value != "" && !p.pattern.MatchString(p.value) {
+			return &ValidationError{Field: p.field, Reason: fmt.Sprintf("should match '%s'", p.pattern), Attribute: true}
+		}
+	}
+	if err := checkEnum("account_classification", at.AccountClassification, accountClassifications, true); err != nil {
+		return err
+	}
+	if err := checkNames("name", at.Name, MaxNames); err != nil {
+		return err
+	}
+	return checkNames("alternative_names", at.AlternativeNames, maxAlternativeNames)
+}
+
+func checkUUID(field, value string) error {
+	if value == "" {
+		return &ValidationError{Field: field, Reason: "is required"}
+	}
+	if _, err := uuid.Parse(value); err != nil {
+		return &ValidationError{Field: field, Reason: fmt.Sprintf("must be of type uuid: %q", value)}
+	}
+	return nil
+}
+
+func checkEnum(field, value string, allowed []string, attribute bool) error {
+	if value == "" && attribute { // optional attributes
+		return nil
+	}
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return &ValidationError{Field: field, Reason: fmt.Sprintf("should be one of [%s]", strings.Join(allowed, " ")), Attribute: attribute}
+}
+
+func checkNames(field string, names []string, maxItems int) error {
+	if len(names) > maxItems {
+		return &ValidationError{Field: field, Reason: fmt.Sprintf("should have at most %d items", maxItems), Attribute: true}
+	}
+	for _, name := range names {
+		if len(name) > maxNameLength {
+			return &ValidationError{Field: field, Reason: fmt.Sprintf("should be at most %d chars long", maxNameLength), Attribute: true}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package accountio
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"go.form3-client.com/account"
+)
+
+// Walker is the part of account.AccountClient the exporter needs
+type Walker interface {
+	WalkAccounts(ctx context.Context, pageSize int, fn func(*account.AccountData) error) error
+}
+
+type ExportOptions struct {
+	Format   Format
+	Fields   []string // the fields to export, see FieldNames; every field when empty
+	PageSize int      // accounts fetched per list request; account.DefaultPageSize when not set
+}
+
+// Export streams every account to out, one page at a time, and returns how many accounts were written
+func Export(ctx context.Context, walker Walker, out io.Writer, opts ExportOptions) (int, error) {
+	selected, err := lookupFields(opts.Fields)
+	if err != nil {
+		return 0, err
+	}
+
+	var write func(a *account.AccountData) error
+	var flush func() error
+	switch opts.Format {
+	case FormatJSONL:
+		encoder := json.NewEncoder(out)
+		write = func(a *account.AccountData) error {
+			if len(opts.Fields) == 0 {
+				return encoder.Encode(a)
+			}
+			projected, err := project(a, selected)
+			if err != nil {
+				return err
+			}
+			return encoder.Encode(projected)
+		}
+		flush = func() error { return nil }
+	case FormatCSV:
+		writer := csv.NewWriter(out)
+		header := make([]string, len(selected))
+		for i, f := range selected {
+			header[i] = f.name
+		}
+		if err := writer.Write(header); err != nil {
+			return 0, fmt.Errorf("could not write the csv header: %w", err)
+		}
+		row := make([]string, len(selected))
+		write = func(a *account.AccountData) error {
+			for i, f := range selected {
+				row[i] = f.get(a)
+			}
+			return writer.Write(row)
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	default:
+		return 0, fmt.Errorf("unsupported export format %q", opts.Format)
+	}
+
+	written := 0
+	err = walker.WalkAccounts(ctx, opts.PageSize, func(a *account.AccountData) error {
+		if err := write(a); err != nil {
+			return fmt.Errorf("could not write account %s: %w", a.ID, err)
+		}
+		written++
+		return nil
+	})
+	if flushErr := flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("could not write the export: %w", flushErr)
+	}
+	return written, err
+}
+
+// project keeps the selected fields of the account as they would appear in its json form
+func project(a *account.AccountData, selected []field) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	var full map[string]interface{}
+	if err := json.Unmarshal(encoded, &full); err != nil {
+		return nil, err
+	}
+	attributes, _ := full["attributes"].(map[string]interface{})
+
+	projected := make(map[string]interface{})
+	projectedAttributes := make(map[string]interface{})
+	for _, f := range selected {
+		if value, ok := full[f.name]; ok && f.name != "attributes" {
+			projected[f.name] = value
+		} else if value, ok := attributes[f.name]; ok {
+			projectedAttributes[f.name] = value
+		}
+	}
+	if len(projectedAttributes) > 0 {
+		projected["attributes"] = projectedAttributes
+	}
+	return projected, nil
+}
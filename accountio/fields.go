@@ -0,0 +1,158 @@
+// Package accountio moves accounts between the API and flat files: JSONL, one AccountData per line, and CSV
+// with one column per field.
+package accountio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.form3-client.com/account"
+)
+
+// ListSeparator joins the name and alternative_names lists in a single CSV cell
+const ListSeparator = ";"
+
+// field is a flattened view of an AccountData entry, named after its json tag
+type field struct {
+	name string
+	get  func(a *account.AccountData) string
+	set  func(a *account.AccountData, value string) error
+}
+
+// attributes is what the setters write to, it allocates the attributes on first use
+func attributes(a *account.AccountData) *account.AccountAttributes {
+	if a.Attributes == nil {
+		a.Attributes = &account.AccountAttributes{}
+	}
+	return a.Attributes
+}
+
+// readAttributes is what the getters read from, it leaves the account untouched
+func readAttributes(a *account.AccountData) *account.AccountAttributes {
+	if a.Attributes == nil {
+		return &account.AccountAttributes{}
+	}
+	return a.Attributes
+}
+
+func stringAttribute(name string, ref func(at *account.AccountAttributes) *string) field {
+	return field{
+		name: name,
+		get:  func(a *account.AccountData) string { return *ref(readAttributes(a)) },
+		set: func(a *account.AccountData, value string) error {
+			*ref(attributes(a)) = value
+			return nil
+		},
+	}
+}
+
+func boolAttribute(name string, ref func(at *account.AccountAttributes) *bool) field {
+	return field{
+		name: name,
+		get:  func(a *account.AccountData) string { return strconv.FormatBool(*ref(readAttributes(a))) },
+		set: func(a *account.AccountData, value string) error {
+			if value == "" {
+				return nil
+			}
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s is not a boolean: %q", name, value)
+			}
+			*ref(attributes(a)) = parsed
+			return nil
+		},
+	}
+}
+
+func listAttribute(name string, ref func(at *account.AccountAttributes) *[]string) field {
+	return field{
+		name: name,
+		get:  func(a *account.AccountData) string { return strings.Join(*ref(readAttributes(a)), ListSeparator) },
+		set: func(a *account.AccountData, value string) error {
+			if value == "" {
+				return nil
+			}
+			*ref(attributes(a)) = strings.Split(value, ListSeparator)
+			return nil
+		},
+	}
+}
+
+var fields = []field{
+	{
+		name: "id",
+		get:  func(a *account.AccountData) string { return a.ID },
+		set:  func(a *account.AccountData, value string) error { a.ID = value; return nil },
+	},
+	{
+		name: "organisation_id",
+		get:  func(a *account.AccountData) string { return a.OrganisationID },
+		set:  func(a *account.AccountData, value string) error { a.OrganisationID = value; return nil },
+	},
+	{
+		name: "type",
+		get:  func(a *account.AccountData) string { return a.Type },
+		set:  func(a *account.AccountData, value string) error { a.Type = value; return nil },
+	},
+	{
+		name: "version",
+		get:  func(a *account.AccountData) string { return strconv.FormatInt(a.Version, 10) },
+		set: func(a *account.AccountData, value string) error {
+			if value == "" {
+				return nil
+			}
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("version is not an integer: %q", value)
+			}
+			a.Version = parsed
+			return nil
+		},
+	},
+	stringAttribute("country", func(at *account.AccountAttributes) *string { return &at.Country }),
+	stringAttribute("bank_id", func(at *account.AccountAttributes) *string { return &at.BankID }),
+	stringAttribute("bank_id_code", func(at *account.AccountAttributes) *string { return &at.BankIDCode }),
+	stringAttribute("bic", func(at *account.AccountAttributes) *string { return &at.Bic }),
+	stringAttribute("account_number", func(at *account.AccountAttributes) *string { return &at.AccountNumber }),
+	stringAttribute("iban", func(at *account.AccountAttributes) *string { return &at.Iban }),
+	stringAttribute("base_currency", func(at *account.AccountAttributes) *string { return &at.BaseCurrency }),
+	stringAttribute("account_classification", func(at *account.AccountAttributes) *string { return &at.AccountClassification }),
+	listAttribute("name", func(at *account.AccountAttributes) *[]string { return &at.Name }),
+	listAttribute("alternative_names", func(at *account.AccountAttributes) *[]string { return &at.AlternativeNames }),
+	stringAttribute("secondary_identification", func(at *account.AccountAttributes) *string { return &at.SecondaryIdentification }),
+	stringAttribute("status", func(at *account.AccountAttributes) *string { return &at.Status }),
+	boolAttribute("joint_account", func(at *account.AccountAttributes) *bool { return &at.JointAccount }),
+	boolAttribute("account_matching_opt_out", func(at *account.AccountAttributes) *bool { return &at.AccountMatchingOptOut }),
+	boolAttribute("switched", func(at *account.AccountAttributes) *bool { return &at.Switched }),
+}
+
+// FieldNames lists every field known to the CSV import and export, in their default column order
+func FieldNames() []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+func lookupFields(names []string) ([]field, error) {
+	if len(names) == 0 {
+		return fields, nil
+	}
+	selected := make([]field, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, f := range fields {
+			if f.name == name {
+				selected = append(selected, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown account field %q", name)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,306 @@
+package accountio
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"go.form3-client.com/account"
+)
+
+type Format string
+
+const (
+	FormatJSONL Format = "jsonl"
+	FormatCSV   Format = "csv"
+)
+
+const (
+	DefaultBatchSize = 100
+	maxLineLength    = 1024 * 1024
+)
+
+// CSVMapping maps a field name to the CSV column holding it. Unmapped fields are read from the column named
+// after the field, if there is one.
+type CSVMapping map[string]string
+
+type ImportOptions struct {
+	Format     Format
+	CSVMapping CSVMapping
+	BatchSize  int                 // records sent per bulk create; DefaultBatchSize when not set
+	Bulk       account.BulkOptions // StopOnError ends the import after the first batch with a failure; OnResult is set by Import
+	Handled    map[int]bool        // lines an earlier import already created or reported invalid, see HandledLines
+}
+
+// Creator is the part of account.AccountClient the importer needs
+type Creator interface {
+	CreateAccounts(ctx context.Context, accounts []*account.AccountData, opts account.BulkOptions) ([]account.BulkResult, account.BulkSummary)
+}
+
+const (
+	StatusCreated = "created"
+	StatusInvalid = "invalid"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// LineResult is written to the results file as one JSON object per imported record
+type LineResult struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportSummary struct {
+	Records int
+	Created int
+	Invalid int
+	Failed  int
+	Skipped int
+}
+
+type record struct {
+	line    int
+	account *account.AccountData
+	err     error // the record could not be parsed or is not valid
+}
+
+// recordReader returns io.EOF once the input is exhausted
+type recordReader interface {
+	next() (record, error)
+}
+
+// Import streams the accounts from in, validates them and creates the valid ones in batches. The outcome of
+// every record is written to results as soon as it is known, so a crashed or stopped import can be resumed from
+// HandledLines of its results without sending again what already went through.
+func Import(ctx context.Context, creator Creator, in io.Reader, results io.Writer, opts ImportOptions) (ImportSummary, error) {
+	var summary ImportSummary
+	reader, err := newRecordReader(in, opts)
+	if err != nil {
+		return summary, err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	// the results of a batch come from the workers of the bulk create, so the summary and results are locked
+	var mu sync.Mutex
+	var writeErr error
+	encoder := json.NewEncoder(results)
+	write := func(result LineResult) {
+		if writeErr == nil {
+			if err := encoder.Encode(result); err != nil {
+				writeErr = fmt.Errorf("could not write the import results: %w", err)
+			}
+		}
+	}
+
+	pending := make([]record, 0, batchSize)
+	// flush creates the pending valid records and reports whether the import should stop
+	flush := func() (bool, error) {
+		valid := make([]record, 0, len(pending))
+		accounts := make([]*account.AccountData, 0, len(pending))
+		for _, r := range pending {
+			if r.err != nil {
+				result := LineResult{Line: r.line, Status: StatusInvalid, Error: r.err.Error()}
+				if r.account != nil {
+					result.ID = r.account.ID
+				}
+				summary.Invalid++
+				write(result)
+				continue
+			}
+			valid = append(valid, r)
+			accounts = append(accounts, r.account)
+		}
+
+		failed := false
+		bulk := opts.Bulk
+		bulk.OnResult = func(i int, created account.BulkResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			result := LineResult{Line: valid[i].line, ID: valid[i].account.ID}
+			switch created.Outcome {
+			case account.OutcomeSucceeded:
+				result.Status = StatusCreated
+				summary.Created++
+			case account.OutcomeSkipped:
+				result.Status, result.Error = StatusSkipped, created.Err.Error()
+				summary.Skipped++
+			default:
+				result.Status, result.Error = StatusFailed, created.Err.Error()
+				summary.Failed++
+				failed = true
+			}
+			write(result)
+		}
+		creator.CreateAccounts(ctx, accounts, bulk)
+
+		pending = pending[:0]
+		if writeErr != nil {
+			return true, writeErr
+		}
+		return (failed && opts.Bulk.StopOnError) || ctx.Err() != nil, ctx.Err()
+	}
+
+	for {
+		r, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+		if opts.Handled[r.line] {
+			continue
+		}
+
+		summary.Records++
+		if r.err == nil {
+			if r.account.Type == "" {
+				r.account.Type = "accounts"
+			}
+			r.err = r.account.Validate()
+		}
+		pending = append(pending, r)
+		if len(pending) == batchSize {
+			if stop, err := flush(); stop || err != nil {
+				return summary, err
+			}
+		}
+	}
+	_, err = flush()
+	return summary, err
+}
+
+// HandledLines reads the results of the earlier imports of an input and returns the lines they created or
+// reported invalid, to be used as ImportOptions.Handled. The lines that failed, were skipped or have no result,
+// because they were still in flight or never sent, are sent again on resume.
+func HandledLines(results io.Reader) (map[int]bool, error) {
+	scanner := bufio.NewScanner(results)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	handled := make(map[int]bool)
+	for scanner.Scan() {
+		var result LineResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue // a line cut short by a crash
+		}
+		if result.Status == StatusCreated || result.Status == StatusInvalid {
+			handled[result.Line] = true
+		}
+	}
+	return handled, scanner.Err()
+}
+
+func newRecordReader(in io.Reader, opts ImportOptions) (recordReader, error) {
+	switch opts.Format {
+	case FormatJSONL:
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+		return &jsonlReader{scanner: scanner}, nil
+	case FormatCSV:
+		return newCSVReader(in, opts.CSVMapping)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", opts.Format)
+	}
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonlReader) next() (record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		var data account.AccountData
+		if err := json.Unmarshal([]byte(text), &data); err != nil {
+			return record{line: r.line, err: fmt.Errorf("invalid json: %w", err)}, nil
+		}
+		return record{line: r.line, account: &data}, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return record{}, fmt.Errorf("could not read line %d: %w", r.line+1, err)
+	}
+	return record{}, io.EOF
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns []csvColumn // in the order of the field registry, so the id is set before any other field can fail
+}
+
+type csvColumn struct {
+	index int
+	field field
+}
+
+func newCSVReader(in io.Reader, mapping CSVMapping) (*csvReader, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1 // short rows are reported per line rather than failing the import
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the csv header: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[strings.TrimSpace(column)] = i
+	}
+
+	columns := make([]csvColumn, 0, len(fields))
+	for _, f := range fields {
+		column, mapped := mapping[f.name]
+		if !mapped {
+			column = f.name
+		}
+		i, ok := index[column]
+		if !ok {
+			if mapped {
+				return nil, fmt.Errorf("column %q mapped to %s is not in the csv header", column, f.name)
+			}
+			continue
+		}
+		columns = append(columns, csvColumn{index: i, field: f})
+	}
+	for name := range mapping {
+		if _, err := lookupFields([]string{name}); err != nil {
+			return nil, fmt.Errorf("invalid csv mapping: %w", err)
+		}
+	}
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvReader) next() (record, error) {
+	row, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return record{line: parseErr.StartLine, err: parseErr}, nil
+		}
+		return record{}, err
+	}
+	line, _ := r.reader.FieldPos(0)
+
+	data := &account.AccountData{}
+	for _, c := range r.columns {
+		if c.index >= len(row) {
+			return record{line: line, err: fmt.Errorf("missing column for %s", c.field.name)}, nil
+		}
+		if err := c.field.set(data, strings.TrimSpace(row[c.index])); err != nil {
+			return record{line: line, account: data, err: err}, nil
+		}
+	}
+	return record{line: line, account: data}, nil
+}
@@ -0,0 +1,235 @@
+//go:build unit
+// +build unit
+
+package accountio
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.form3-client.com/account"
+)
+
+type fakeCreator struct {
+	mu      sync.Mutex
+	created []*account.AccountData
+	failIDs map[string]bool
+	crashAt string // panic when creating this id, after the results of the earlier items were reported
+}
+
+func (f *fakeCreator) CreateAccounts(ctx context.Context, accounts []*account.AccountData, opts account.BulkOptions) ([]account.BulkResult, account.BulkSummary) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	results := make([]account.BulkResult, len(accounts))
+	for i, a := range accounts {
+		switch {
+		case a.ID == f.crashAt:
+			panic("crashed")
+		case f.failIDs[a.ID]:
+			err := &account.ResponseError{StatusCode: 409, Message: "Account cannot be created as it violates a duplicate constraint"}
+			results[i] = account.BulkResult{Err: err, Outcome: account.OutcomeConflict}
+		default:
+			f.created = append(f.created, a)
+			results[i] = account.BulkResult{Account: a, Outcome: account.OutcomeSucceeded}
+		}
+		if opts.OnResult != nil {
+			opts.OnResult(i, results[i])
+		}
+	}
+	return results, account.BulkSummary{}
+}
+
+const (
+	firstID  = "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	secondID = "bd27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	thirdID  = "cd27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	orgID    = "eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"
+)
+
+// JSONL records are validated, created and reported line by line
+func TestImportJSONL(t *testing.T) {
+	// WHEN
+	input := strings.Join([]string{
+		`{"id": "` + firstID + `", "organisation_id": "` + orgID + `", "attributes": {"country": "GB", "name": ["John"]}}`,
+		``,
+		`{"id": "` + secondID + `", "organisation_id": "` + orgID + `", "attributes": {"country": "gb"}}`,
+		`{not json`,
+		`{"id": "` + thirdID + `", "organisation_id": "` + orgID + `", "attributes": {"country": "RO"}}`,
+	}, "\n")
+	creator := &fakeCreator{failIDs: map[string]bool{thirdID: true}}
+	var results bytes.Buffer
+
+	// THEN
+	summary, err := Import(context.Background(), creator, strings.NewReader(input), &results, ImportOptions{Format: FormatJSONL, BatchSize: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, ImportSummary{Records: 4, Created: 1, Invalid: 2, Failed: 1}, summary)
+	assert.Equal(t, "accounts", creator.created[0].Type)
+	assert.Equal(t, strings.Join([]string{
+		`{"line":3,"id":"` + secondID + `","status":"invalid","error":"country in body should match '^[A-Z]{2}$'"}`,
+		`{"line":1,"id":"` + firstID + `","status":"created"}`,
+		`{"line":4,"status":"invalid","error":"invalid json: invalid character 'n' looking for beginning of object key string"}`,
+		`{"line":5,"id":"` + thirdID + `","status":"failed","error":"response status code 409 with error message: Account cannot be created as it violates a duplicate constraint"}`,
+		``,
+	}, "\n"), results.String())
+
+	handled, err := HandledLines(&results)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]bool{1: true, 3: true, 4: true}, handled)
+}
+
+// Resuming sends again only the lines that did not go through, whatever finished after them
+func TestImportResumesAfterAFailure(t *testing.T) {
+	// WHEN
+	var lines []string
+	for _, id := range []string{firstID, secondID, thirdID} {
+		lines = append(lines, `{"id": "`+id+`", "organisation_id": "`+orgID+`", "attributes": {"country": "GB"}}`)
+	}
+	input := strings.Join(lines, "\n")
+	creator := &fakeCreator{failIDs: map[string]bool{secondID: true}} // a failure that goes away
+	var results bytes.Buffer
+	summary, err := Import(context.Background(), creator, strings.NewReader(input), &results, ImportOptions{Format: FormatJSONL})
+	require.NoError(t, err)
+	assert.Equal(t, ImportSummary{Records: 3, Created: 2, Failed: 1}, summary)
+
+	// THEN
+	handled, err := HandledLines(strings.NewReader(results.String() + `{"line":4,"sta`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]bool{1: true, 3: true}, handled, "a line cut short by a crash is ignored")
+
+	creator.failIDs = nil
+	summary, err = Import(context.Background(), creator, strings.NewReader(input), &results, ImportOptions{Format: FormatJSONL, Handled: handled})
+	assert.Nil(t, err)
+	assert.Equal(t, ImportSummary{Records: 1, Created: 1}, summary)
+	assert.Equal(t, secondID, creator.created[2].ID)
+
+	handled, err = HandledLines(&results)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]bool{1: true, 2: true, 3: true}, handled)
+}
+
+// The records created before a crash in the middle of a batch are in the results and are not sent again
+func TestImportResumesAfterACrashMidBatch(t *testing.T) {
+	// WHEN
+	var lines []string
+	for _, id := range []string{firstID, secondID, thirdID} {
+		lines = append(lines, `{"id": "`+id+`", "organisation_id": "`+orgID+`", "attributes": {"country": "GB"}}`)
+	}
+	input := strings.Join(lines, "\n")
+	creator := &fakeCreator{crashAt: secondID}
+	var results bytes.Buffer
+
+	// THEN
+	assert.Panics(t, func() {
+		_, _ = Import(context.Background(), creator, strings.NewReader(input), &results, ImportOptions{Format: FormatJSONL})
+	})
+	handled, err := HandledLines(&results)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]bool{1: true}, handled)
+
+	creator.crashAt = ""
+	summary, err := Import(context.Background(), creator, strings.NewReader(input), &results, ImportOptions{Format: FormatJSONL, Handled: handled})
+	assert.Nil(t, err)
+	assert.Equal(t, ImportSummary{Records: 2, Created: 2}, summary)
+	assert.Len(t, creator.created, 3)
+}
+
+// CSV columns are mapped to fields and lists are split on the separator
+func TestImportCSVWithMappingAndResume(t *testing.T) {
+	// WHEN
+	input := "Account ID,org,country,Holder names,switched\n" +
+		firstID + "," + orgID + ",GB,John;Doe,true\n" +
+		secondID + "," + orgID + ",FR,Jane,maybe\n" +
+		thirdID + "," + orgID + ",RO,Joe,false\n"
+	mapping := CSVMapping{"id": "Account ID", "organisation_id": "org", "name": "Holder names"}
+	creator := &fakeCreator{}
+	var results bytes.Buffer
+
+	// THEN
+	summary, err := Import(context.Background(), creator, strings.NewReader(input), &results, ImportOptions{Format: FormatCSV, CSVMapping: mapping, Handled: map[int]bool{2: true}})
+	assert.Nil(t, err)
+	assert.Equal(t, ImportSummary{Records: 2, Created: 1, Invalid: 1}, summary)
+	assert.Len(t, creator.created, 1)
+	assert.Equal(t, thirdID, creator.created[0].ID)
+	assert.Equal(t, []string{"Joe"}, creator.created[0].Attributes.Name)
+	assert.Contains(t, results.String(), `"line":3,"id":"`+secondID+`","status":"invalid","error":"switched is not a boolean: \"maybe\""`)
+
+	_, err = Import(context.Background(), creator, strings.NewReader(input), &results, ImportOptions{Format: FormatCSV, CSVMapping: CSVMapping{"iban": "IBAN"}})
+	assert.EqualError(t, err, `column "IBAN" mapped to iban is not in the csv header`)
+}
+
+// Failing records stop the import when asked to
+func TestImportStopsOnError(t *testing.T) {
+	// WHEN
+	var lines []string
+	for _, id := range []string{firstID, secondID, thirdID} {
+		lines = append(lines, `{"id": "`+id+`", "organisation_id": "`+orgID+`", "attributes": {"country": "GB"}}`)
+	}
+	creator := &fakeCreator{failIDs: map[string]bool{firstID: true}}
+	var results bytes.Buffer
+
+	// THEN
+	opts := ImportOptions{Format: FormatJSONL, BatchSize: 1, Bulk: account.BulkOptions{StopOnError: true}}
+	summary, err := Import(context.Background(), creator, strings.NewReader(strings.Join(lines, "\n")), &results, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, ImportSummary{Records: 1, Failed: 1}, summary)
+	assert.Empty(t, creator.created)
+
+	handled, err := HandledLines(&results)
+	assert.Nil(t, err)
+	assert.Empty(t, handled)
+}
+
+type fakeWalker []*account.AccountData
+
+func (f fakeWalker) WalkAccounts(ctx context.Context, pageSize int, fn func(*account.AccountData) error) error {
+	for _, a := range f {
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var exported = fakeWalker{
+	{ID: firstID, OrganisationID: orgID, Type: "accounts", Version: 2, Attributes: &account.AccountAttributes{
+		Country: "GB", Name: []string{"John", "Doe"}, AlternativeNames: []string{"JD"}, Switched: true}},
+	{ID: secondID, OrganisationID: orgID, Type: "accounts"},
+}
+
+// CSV exports flatten the attributes into the selected columns
+func TestExportCSV(t *testing.T) {
+	// WHEN
+	var out bytes.Buffer
+	opts := ExportOptions{Format: FormatCSV, Fields: []string{"id", "version", "country", "name", "alternative_names", "switched"}}
+
+	// THEN
+	written, err := Export(context.Background(), exported, &out, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, written)
+	assert.Equal(t, "id,version,country,name,alternative_names,switched\n"+
+		firstID+",2,GB,John;Doe,JD,true\n"+
+		secondID+",0,,,,false\n", out.String())
+
+	_, err = Export(context.Background(), exported, &out, ExportOptions{Format: FormatCSV, Fields: []string{"nope"}})
+	assert.EqualError(t, err, `unknown account field "nope"`)
+}
+
+// JSONL exports keep the json shape of the selected fields
+func TestExportJSONL(t *testing.T) {
+	// WHEN
+	var all, selected bytes.Buffer
+
+	// THEN
+	_, err := Export(context.Background(), exported, &all, ExportOptions{Format: FormatJSONL})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"`+secondID+`","organisation_id":"`+orgID+`","type":"accounts"}`, strings.Split(all.String(), "\n")[1])
+
+	_, err = Export(context.Background(), exported, &selected, ExportOptions{Format: FormatJSONL, Fields: []string{"id", "name"}})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"attributes":{"name":["John","Doe"]},"id":"`+firstID+`"}`+"\n"+`{"id":"`+secondID+`"}`+"\n", selected.String())
+}
@@ -0,0 +1,358 @@
+// Package accountload drives an account client at a target rate or concurrency with a mix of creates, gets
+// and deletes, and reports the latency percentiles, the errors and the retries it saw.
+package accountload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/google/uuid"
+	"go.form3-client.com/account"
+)
+
+const DefaultConcurrency = 10
+
+// Client is the part of account.AccountClient a load run needs
+type Client interface {
+	CreateAccount(ctx context.Context, acc *account.AccountData) (*account.AccountData, error)
+	GetById(ctx context.Context, accountId string) (*account.AccountData, error)
+	DeleteAccount(ctx context.Context, accountId string, version int64) error
+}
+
+// Mix weighs the operations, e.g. 2 creates for 5 gets and 1 delete. Gets and deletes need an account
+// created by the run, they are turned into creates until there is one.
+type Mix struct {
+	Create int `json:"create"`
+	Get    int `json:"get"`
+	Delete int `json:"delete"`
+}
+
+// ParseMix reads a mix written as create=2,get=5,delete=1; missing operations weigh 0
+func ParseMix(s string) (Mix, error) {
+	var mix Mix
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		weight, err := strconv.Atoi(value)
+		if !ok || err != nil || weight < 0 {
+			return Mix{}, fmt.Errorf("invalid mix %q, expected e.g. create=2,get=5,delete=1", s)
+		}
+		switch account.Operation(name) {
+		case account.OperationCreate:
+			mix.Create = weight
+		case account.OperationGet:
+			mix.Get = weight
+		case account.OperationDelete:
+			mix.Delete = weight
+		default:
+			return Mix{}, fmt.Errorf("invalid mix %q, unknown operation %q", s, name)
+		}
+	}
+	return mix, nil
+}
+
+func (m Mix) total() int {
+	return m.Create + m.Get + m.Delete
+}
+
+func (m Mix) pick(r *rand.Rand) account.Operation {
+	n := r.Intn(m.total())
+	switch {
+	case n < m.Create:
+		return account.OperationCreate
+	case n < m.Create+m.Get:
+		return account.OperationGet
+	default:
+		return account.OperationDelete
+	}
+}
+
+type Config struct {
+	// Rate is how many operations start every second. The latency is measured from when an operation was
+	// due rather than from when a worker got to it, so a saturated API shows in the percentiles. With 0 the
+	// workers run one operation after the other as fast as the API answers.
+	Rate        float64
+	Concurrency int           // workers, DefaultConcurrency when 0
+	Duration    time.Duration // the run stops after this long, or after Requests, whichever comes first
+	Requests    int
+	Mix         Mix
+	Timeout     time.Duration // of every operation, account.ClientTimeout when 0
+	Seed        int64         // of the operation mix, every worker draws from its own source
+	// OrganisationID owns the created accounts, a random one when empty
+	OrganisationID string
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if c.Duration <= 0 && c.Requests <= 0 {
+		return c, errors.New("a load run needs a duration or a number of requests")
+	}
+	if c.Mix.total() <= 0 || c.Mix.Create < 0 || c.Mix.Get < 0 || c.Mix.Delete < 0 {
+		return c, errors.New("the operation mix needs a positive weight")
+	}
+	if c.Rate < 0 {
+		return c, errors.New("the rate cannot be negative")
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = account.ClientTimeout
+	}
+	if c.OrganisationID == "" {
+		c.OrganisationID = uuid.New().String()
+	}
+	return c, nil
+}
+
+type attemptsKey struct{}
+
+// CountAttempts wraps the transport of the client under load, so the retries of the client can be told
+// apart from the operations; without it the report counts no retries
+func CountAttempts(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return attemptCounter{next: next}
+}
+
+type attemptCounter struct {
+	next http.RoundTripper
+}
+
+func (c attemptCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if attempts, ok := req.Context().Value(attemptsKey{}).(*int32); ok {
+		atomic.AddInt32(attempts, 1)
+	}
+	return c.next.RoundTrip(req)
+}
+
+// pool holds the accounts created by the run, which the gets and the deletes pick from. An account is
+// taken out while an operation uses it, so a get never races a delete into a not found.
+type pool struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (p *pool) add(id string) {
+	p.mu.Lock()
+	p.ids = append(p.ids, id)
+	p.mu.Unlock()
+}
+
+func (p *pool) take(r *rand.Rand) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ids) == 0 {
+		return "", false
+	}
+	i := r.Intn(len(p.ids))
+	id := p.ids[i]
+	p.ids[i] = p.ids[len(p.ids)-1]
+	p.ids = p.ids[:len(p.ids)-1]
+	return id, true
+}
+
+// Run drives the client until the duration or the number of requests is reached, or ctx is done. The
+// operations in flight at the end are waited for.
+func Run(ctx context.Context, client Client, cfg Config) (*Report, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	scheduleCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if cfg.Duration > 0 {
+		scheduleCtx, cancel = context.WithTimeout(scheduleCtx, cfg.Duration)
+		defer cancel()
+	}
+
+	start := time.Now()
+	due := make(chan time.Time, cfg.Concurrency)
+	go schedule(scheduleCtx, cfg, start, due)
+
+	accounts := &pool{}
+	workers := make([]*stats, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i] = newStats()
+		wg.Add(1)
+		go func(s *stats, r *rand.Rand) {
+			defer wg.Done()
+			for dueAt := range due {
+				runOperation(ctx, client, cfg, accounts, r, s, dueAt)
+			}
+		}(workers[i], rand.New(rand.NewSource(cfg.Seed+int64(i))))
+	}
+	wg.Wait()
+
+	total := newStats()
+	for _, s := range workers {
+		total.merge(s)
+	}
+	return total.report(time.Since(start)), nil
+}
+
+// schedule hands out the operations: on time when there is a rate, as soon as a worker is free otherwise
+func schedule(ctx context.Context, cfg Config, start time.Time, due chan<- time.Time) {
+	defer close(due)
+	for i := 0; cfg.Requests <= 0 || i < cfg.Requests; i++ {
+		var dueAt time.Time // zero lets the worker start the clock
+		if cfg.Rate > 0 {
+			dueAt = start.Add(time.Duration(float64(i) / cfg.Rate * float64(time.Second)))
+			if wait := time.Until(dueAt); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		}
+		select {
+		case due <- dueAt:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func runOperation(ctx context.Context, client Client, cfg Config, accounts *pool, r *rand.Rand, s *stats, dueAt time.Time) {
+	if dueAt.IsZero() {
+		dueAt = time.Now()
+	}
+	attempts := new(int32)
+	opCtx, cancel := context.WithTimeout(context.WithValue(ctx, attemptsKey{}, attempts), cfg.Timeout)
+	defer cancel()
+
+	op := cfg.Mix.pick(r)
+	var id string
+	if op != account.OperationCreate {
+		var ok bool
+		if id, ok = accounts.take(r); !ok {
+			op = account.OperationCreate
+		}
+	}
+
+	var err error
+	switch op {
+	case account.OperationCreate:
+		id = uuid.New().String()
+		_, err = client.CreateAccount(opCtx, &account.AccountData{
+			ID:             id,
+			OrganisationID: cfg.OrganisationID,
+			Type:           "accounts",
+			Attributes:     &account.AccountAttributes{Country: "GB", Name: []string{"load", "test"}},
+		})
+		if err == nil {
+			accounts.add(id)
+		}
+	case account.OperationGet:
+		_, err = client.GetById(opCtx, id)
+		accounts.add(id)
+	case account.OperationDelete:
+		err = client.DeleteAccount(opCtx, id, 0) // the run never updates its accounts
+	}
+	s.record(op, time.Since(dueAt), err, atomic.LoadInt32(attempts))
+}
+
+// classify names the kind of failure an operation ended with
+func classify(err error) string {
+	var responseErr *account.ResponseError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, account.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, account.ErrConflict):
+		return "conflict"
+	case errors.As(err, &responseErr) && responseErr.StatusCode >= 500:
+		return "server_error"
+	case errors.As(err, &responseErr):
+		return "client_error"
+	default:
+		return "other"
+	}
+}
+
+const (
+	lowestLatency  = 1                     // microsecond
+	highestLatency = 10 * 60 * 1000 * 1000 // 10 minutes in microseconds
+	latencyDigits  = 3
+)
+
+func newHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(lowestLatency, highestLatency, latencyDigits)
+}
+
+// stats are kept per worker, histograms are not safe for concurrent use
+type stats struct {
+	histograms map[account.Operation]*hdrhistogram.Histogram
+	requests   map[account.Operation]int
+	failures   map[account.Operation]int
+	errors     map[string]int
+	retries    int64
+}
+
+func newStats() *stats {
+	return &stats{
+		histograms: make(map[account.Operation]*hdrhistogram.Histogram),
+		requests:   make(map[account.Operation]int),
+		failures:   make(map[account.Operation]int),
+		errors:     make(map[string]int),
+	}
+}
+
+func (s *stats) histogram(op account.Operation) *hdrhistogram.Histogram {
+	h, ok := s.histograms[op]
+	if !ok {
+		h = newHistogram()
+		s.histograms[op] = h
+	}
+	return h
+}
+
+func (s *stats) record(op account.Operation, latency time.Duration, err error, attempts int32) {
+	micros := latency.Microseconds()
+	if micros < lowestLatency {
+		micros = lowestLatency
+	}
+	if micros > highestLatency {
+		micros = highestLatency
+	}
+	s.histogram(op).RecordValue(micros)
+	s.requests[op]++
+	if err != nil {
+		s.failures[op]++
+		s.errors[classify(err)]++
+	}
+	if attempts > 1 {
+		s.retries += int64(attempts - 1)
+	}
+}
+
+func (s *stats) merge(other *stats) {
+	for op, h := range other.histograms {
+		s.histogram(op).Merge(h)
+	}
+	for op, n := range other.requests {
+		s.requests[op] += n
+	}
+	for op, n := range other.failures {
+		s.failures[op] += n
+	}
+	for class, n := range other.errors {
+		s.errors[class] += n
+	}
+	s.retries += other.retries
+}
@@ -0,0 +1,114 @@
+package accountload
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"go.form3-client.com/account"
+)
+
+// Latency holds the percentiles of a histogram, in milliseconds
+type Latency struct {
+	Min  float64 `json:"min_ms"`
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+func latencyOf(h *hdrhistogram.Histogram) Latency {
+	millis := func(micros int64) float64 { return float64(micros) / 1000 }
+	return Latency{
+		Min:  millis(h.Min()),
+		Mean: h.Mean() / 1000,
+		P50:  millis(h.ValueAtQuantile(50)),
+		P90:  millis(h.ValueAtQuantile(90)),
+		P99:  millis(h.ValueAtQuantile(99)),
+		P999: millis(h.ValueAtQuantile(99.9)),
+		Max:  millis(h.Max()),
+	}
+}
+
+type OperationReport struct {
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	Latency  Latency `json:"latency"`
+}
+
+type Report struct {
+	Duration   time.Duration `json:"-"`
+	Seconds    float64       `json:"duration_s"`
+	Requests   int           `json:"requests"`
+	Throughput float64       `json:"throughput_rps"`
+	Retries    int64         `json:"retries"`
+	// Errors counts the failed operations by class: timeout, canceled, not_found, conflict, server_error,
+	// client_error and other
+	Errors     map[string]int                         `json:"errors"`
+	Latency    Latency                                `json:"latency"`
+	Operations map[account.Operation]*OperationReport `json:"operations"`
+}
+
+// Failed counts the operations that ended with an error
+func (r *Report) Failed() int {
+	failed := 0
+	for _, n := range r.Errors {
+		failed += n
+	}
+	return failed
+}
+
+func (s *stats) report(elapsed time.Duration) *Report {
+	all := newHistogram()
+	report := &Report{
+		Duration:   elapsed,
+		Seconds:    elapsed.Seconds(),
+		Retries:    s.retries,
+		Errors:     s.errors,
+		Operations: make(map[account.Operation]*OperationReport),
+	}
+	for op, h := range s.histograms {
+		all.Merge(h)
+		report.Operations[op] = &OperationReport{Requests: s.requests[op], Errors: s.failures[op], Latency: latencyOf(h)}
+		report.Requests += s.requests[op]
+	}
+	report.Latency = latencyOf(all)
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+	return report
+}
+
+// String renders the report as a table of the percentiles of every operation
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d requests in %s, %.1f/s, %d failed, %d retries\n\n", r.Requests, r.Duration.Round(time.Millisecond), r.Throughput, r.Failed(), r.Retries)
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "operation\trequests\terrors\tp50 ms\tp90 ms\tp99 ms\tp999 ms\tmax ms\t")
+	row := func(name string, requests, errors int, l Latency) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", name, requests, errors, l.P50, l.P90, l.P99, l.P999, l.Max)
+	}
+	for _, op := range []account.Operation{account.OperationCreate, account.OperationGet, account.OperationDelete} {
+		if o, ok := r.Operations[op]; ok {
+			row(string(op), o.Requests, o.Errors, o.Latency)
+		}
+	}
+	row("all", r.Requests, r.Failed(), r.Latency)
+	w.Flush()
+
+	if len(r.Errors) > 0 {
+		classes := make([]string, 0, len(r.Errors))
+		for class, n := range r.Errors {
+			classes = append(classes, fmt.Sprintf("%s=%d", class, n))
+		}
+		sort.Strings(classes)
+		fmt.Fprintf(&b, "\nerrors: %s\n", strings.Join(classes, " "))
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+//go:build unit
+// +build unit
+
+package accountload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.form3-client.com/account"
+	"go.form3-client.com/accounttest"
+)
+
+func newClient(t *testing.T, server *accounttest.Server) *account.AccountClient {
+	client, err := account.NewAccountClient(server.URL, &http.Client{Timeout: account.ClientTimeout, Transport: CountAttempts(nil)})
+	require.NoError(t, err)
+	return client
+}
+
+// Every operation is counted once and the percentiles are in order
+func TestRunWithRequests(t *testing.T) {
+	// WHEN
+	server := accounttest.NewServer()
+	defer server.Close()
+	report, err := Run(context.Background(), newClient(t, server), Config{
+		Concurrency: 5, Requests: 200, Mix: Mix{Create: 2, Get: 5, Delete: 1}, Seed: 1,
+	})
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, 200, report.Requests)
+	assert.Equal(t, 0, report.Failed())
+	assert.Equal(t, int64(0), report.Retries)
+	sum := 0
+	for _, o := range report.Operations {
+		sum += o.Requests
+	}
+	assert.Equal(t, 200, sum)
+	assert.Greater(t, report.Operations[account.OperationGet].Requests, report.Operations[account.OperationDelete].Requests)
+	created := report.Operations[account.OperationCreate].Requests
+	deleted := report.Operations[account.OperationDelete].Requests
+	assert.Len(t, server.Store.List(), created-deleted, "deletes only remove accounts of the run")
+
+	l := report.Latency
+	assert.True(t, l.Min <= l.P50 && l.P50 <= l.P90 && l.P90 <= l.P99 && l.P99 <= l.P999 && l.P999 <= l.Max, "%+v", l)
+	assert.Contains(t, report.String(), "200 requests in")
+}
+
+// With a rate the operations are spread over the duration
+func TestRunAtRate(t *testing.T) {
+	// WHEN
+	server := accounttest.NewServer()
+	defer server.Close()
+	report, err := Run(context.Background(), newClient(t, server), Config{
+		Rate: 200, Duration: 300 * time.Millisecond, Mix: Mix{Create: 1},
+	})
+
+	// THEN
+	require.NoError(t, err)
+	assert.InDelta(t, 60, report.Requests, 10)
+}
+
+// Retries are counted from the attempts that reach the transport and failures are classified
+func TestRunCountsRetriesAndErrors(t *testing.T) {
+	// WHEN
+	server := accounttest.NewFaultyServer(1, accounttest.Rule{
+		Operations: []account.Operation{account.OperationGet},
+		Faults:     []accounttest.Fault{{Kind: accounttest.FaultServerError, Probability: 1, Times: 2}},
+	})
+	defer server.Close()
+	report, err := Run(context.Background(), newClient(t, server), Config{
+		Concurrency: 1, Requests: 3, Mix: Mix{Get: 1},
+	})
+
+	// THEN
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Operations[account.OperationCreate].Requests, "the first get has no account yet")
+	assert.Equal(t, 2, report.Operations[account.OperationGet].Requests)
+	assert.Equal(t, int64(2), report.Retries)
+	assert.Equal(t, 0, report.Failed())
+
+	assert.Equal(t, "timeout", classify(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "not_found", classify(&account.ResponseError{StatusCode: 404}))
+	assert.Equal(t, "conflict", classify(&account.ResponseError{StatusCode: 409}))
+	assert.Equal(t, "client_error", classify(&account.ResponseError{StatusCode: 400}))
+	assert.Equal(t, "other", classify(errors.New("unexpected response status code: 302")))
+}
+
+func TestParseMix(t *testing.T) {
+	// WHEN
+	mix, err := ParseMix("create=2, get=5,delete=1")
+
+	// THEN
+	assert.NoError(t, err)
+	assert.Equal(t, Mix{Create: 2, Get: 5, Delete: 1}, mix)
+	_, err = ParseMix("create=2,update=1")
+	assert.EqualError(t, err, `invalid mix "create=2,update=1", unknown operation "update"`)
+	_, err = ParseMix("create")
+	assert.Error(t, err)
+	_, err = Run(context.Background(), nil, Config{Requests: 1})
+	assert.EqualError(t, err, "the operation mix needs a positive weight")
+}
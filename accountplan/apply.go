@@ -0,0 +1,73 @@
+package accountplan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.form3-client.com/account"
+)
+
+type ChangeResult struct {
+	Change
+	Account *account.AccountData `json:"account,omitempty"` // the account as the server returned it
+	Error   string               `json:"error,omitempty"`
+}
+
+type ApplyResult struct {
+	Results []ChangeResult `json:"results"`
+	Failed  int            `json:"failed"`
+}
+
+// Apply runs the changes one after the other. Updates and deletes are made against the version seen when the
+// plan was computed, so a record touched in between fails with a conflict instead of being overwritten.
+func Apply(ctx context.Context, client Client, plan *Plan) (*ApplyResult, error) {
+	result := &ApplyResult{Results: make([]ChangeResult, 0, len(plan.Changes))}
+	for _, change := range plan.Changes {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		changeResult := ChangeResult{Change: change}
+		var err error
+		switch change.Action {
+		case ActionCreate:
+			changeResult.Account, err = client.CreateAccount(ctx, change.Desired)
+		case ActionUpdate:
+			var patch *account.AccountPatch
+			if patch, err = change.patch(); err == nil {
+				changeResult.Account, err = client.PatchAccount(ctx, change.ID, change.Version, patch)
+			}
+		case ActionDelete:
+			err = client.DeleteAccount(ctx, change.ID, change.Version)
+		default:
+			err = fmt.Errorf("unknown action %q", change.Action)
+		}
+		if err != nil {
+			changeResult.Account = nil
+			changeResult.Error = err.Error()
+			result.Failed++
+		}
+		result.Results = append(result.Results, changeResult)
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d of %d changes failed", result.Failed, len(plan.Changes))
+	}
+	return result, nil
+}
+
+func (r *ApplyResult) String() string {
+	if len(r.Results) == 0 {
+		return "nothing to apply"
+	}
+	var b strings.Builder
+	for _, c := range r.Results {
+		if c.Error != "" {
+			fmt.Fprintf(&b, "%s %s failed: %s\n", c.Action, c.ID, c.Error)
+		} else {
+			fmt.Fprintf(&b, "%s %s done\n", c.Action, c.ID)
+		}
+	}
+	fmt.Fprintf(&b, "applied %d of %d changes", len(r.Results)-r.Failed, len(r.Results))
+	return b.String()
+}
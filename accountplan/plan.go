@@ -0,0 +1,245 @@
+// Package accountplan converges the live accounts to a desired state manifest: it computes a plan of creates,
+// updates and deletes with field level diffs, then applies it with versioned requests.
+package accountplan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.form3-client.com/account"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is the desired state; it is read from YAML or JSON with the json names of the API
+type Manifest struct {
+	Accounts []*account.AccountData `json:"accounts"`
+}
+
+// ReadManifest parses and validates a manifest; a missing type defaults to "accounts"
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var generic interface{}
+	if err := yaml.NewDecoder(r).Decode(&generic); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse the manifest: %w", err)
+	}
+	encoded, err := json.Marshal(generic)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(encoded, &manifest); err != nil {
+		return nil, fmt.Errorf("could not parse the manifest: %w", err)
+	}
+
+	seen := make(map[string]bool, len(manifest.Accounts))
+	for i, acc := range manifest.Accounts {
+		if acc == nil {
+			return nil, fmt.Errorf("account %d of the manifest is empty", i)
+		}
+		if acc.Type == "" {
+			acc.Type = "accounts"
+		}
+		if err := acc.Validate(); err != nil {
+			return nil, fmt.Errorf("account %d of the manifest: %w", i, err)
+		}
+		if seen[acc.ID] {
+			return nil, fmt.Errorf("account %s is listed twice in the manifest", acc.ID)
+		}
+		seen[acc.ID] = true
+	}
+	return &manifest, nil
+}
+
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// FieldDiff is an attribute that changes; a nil value stands for an attribute that is not set
+type FieldDiff struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type Change struct {
+	Action  Action               `json:"action"`
+	ID      string               `json:"id"`
+	Version int64                `json:"version"` // live version the update or delete is made against
+	Desired *account.AccountData `json:"desired,omitempty"`
+	Diffs   []FieldDiff          `json:"diffs,omitempty"`
+}
+
+type Plan struct {
+	Changes []Change `json:"changes"`
+}
+
+func (p *Plan) IsEmpty() bool {
+	return len(p.Changes) == 0
+}
+
+// Client is the part of account.AccountClient a plan needs
+type Client interface {
+	GetAccounts(ctx context.Context, ids []string, opts account.BulkOptions) (map[string]account.BulkResult, account.BulkSummary)
+	WalkAccounts(ctx context.Context, pageSize int, fn func(*account.AccountData) error) error
+	CreateAccount(ctx context.Context, acc *account.AccountData) (*account.AccountData, error)
+	PatchAccount(ctx context.Context, accountId string, version int64, patch *account.AccountPatch) (*account.AccountData, error)
+	DeleteAccount(ctx context.Context, accountId string, version int64) error
+}
+
+type Options struct {
+	// Prune plans the deletion of the live accounts that are missing from the manifest. Only the organisations
+	// named in the manifest are looked at.
+	Prune bool
+	Bulk  account.BulkOptions
+}
+
+// Compute compares the manifest with the live accounts
+func Compute(ctx context.Context, client Client, manifest *Manifest, opts Options) (*Plan, error) {
+	ids := make([]string, len(manifest.Accounts))
+	for i, acc := range manifest.Accounts {
+		ids[i] = acc.ID
+	}
+	live, _ := client.GetAccounts(ctx, ids, opts.Bulk)
+
+	plan := &Plan{Changes: make([]Change, 0)}
+	for _, desired := range manifest.Accounts {
+		result := live[desired.ID]
+		switch result.Outcome {
+		case account.OutcomeNotFound:
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, ID: desired.ID, Desired: desired, Diffs: diff(nil, desired.Attributes)})
+		case account.OutcomeSucceeded:
+			current := result.Account
+			if current.OrganisationID != desired.OrganisationID {
+				return nil, fmt.Errorf("account %s belongs to organisation %s, it cannot be moved to %s", desired.ID, current.OrganisationID, desired.OrganisationID)
+			}
+			if diffs := diff(current.Attributes, desired.Attributes); len(diffs) > 0 {
+				plan.Changes = append(plan.Changes, Change{Action: ActionUpdate, ID: desired.ID, Version: current.Version, Desired: desired, Diffs: diffs})
+			}
+		default:
+			return nil, fmt.Errorf("could not fetch account %s: %w", desired.ID, result.Err)
+		}
+	}
+
+	if opts.Prune {
+		deletes, err := prune(ctx, client, manifest)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, deletes...)
+	}
+	return plan, nil
+}
+
+func prune(ctx context.Context, client Client, manifest *Manifest) ([]Change, error) {
+	organisations := make(map[string]bool)
+	wanted := make(map[string]bool, len(manifest.Accounts))
+	for _, acc := range manifest.Accounts {
+		organisations[acc.OrganisationID] = true
+		wanted[acc.ID] = true
+	}
+
+	deletes := make([]Change, 0)
+	err := client.WalkAccounts(ctx, account.DefaultPageSize, func(acc *account.AccountData) error {
+		if organisations[acc.OrganisationID] && !wanted[acc.ID] {
+			deletes = append(deletes, Change{Action: ActionDelete, ID: acc.ID, Version: acc.Version, Diffs: diff(acc.Attributes, nil)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list the live accounts: %w", err)
+	}
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i].ID < deletes[j].ID })
+	return deletes, nil
+}
+
+// attributeMap is the json form of the attributes, where unset attributes are left out
+func attributeMap(attributes *account.AccountAttributes) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if attributes == nil {
+		return fields
+	}
+	encoded, _ := json.Marshal(attributes) // plain strings, bools and lists always encode
+	json.Unmarshal(encoded, &fields)
+	return fields
+}
+
+func diff(old, new *account.AccountAttributes) []FieldDiff {
+	oldFields, newFields := attributeMap(old), attributeMap(new)
+	names := make([]string, 0, len(oldFields)+len(newFields))
+	for name := range oldFields {
+		names = append(names, name)
+	}
+	for name := range newFields {
+		if _, ok := oldFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := make([]FieldDiff, 0)
+	for _, name := range names {
+		if !reflect.DeepEqual(oldFields[name], newFields[name]) {
+			diffs = append(diffs, FieldDiff{Field: name, Old: oldFields[name], New: newFields[name]})
+		}
+	}
+	return diffs
+}
+
+// patch turns the diffs into a merge patch, attributes dropped from the manifest are cleared
+func (c *Change) patch() (*account.AccountPatch, error) {
+	changed := make(map[string]interface{}, len(c.Diffs))
+	for _, d := range c.Diffs {
+		changed[d.Field] = d.New
+	}
+	encoded, err := json.Marshal(changed)
+	if err != nil {
+		return nil, err
+	}
+	var patch account.AccountPatch
+	if err := json.Unmarshal(encoded, &patch); err != nil {
+		return nil, err
+	}
+	return &patch, nil
+}
+
+// String renders the plan the way a reviewer reads it: + create, ~ update and - delete with the changed fields
+func (p *Plan) String() string {
+	if p.IsEmpty() {
+		return "no changes, the live accounts match the manifest"
+	}
+	var b strings.Builder
+	counts := make(map[Action]int)
+	for _, c := range p.Changes {
+		counts[c.Action]++
+		switch c.Action {
+		case ActionCreate:
+			fmt.Fprintf(&b, "+ create %s\n", c.ID)
+		case ActionUpdate:
+			fmt.Fprintf(&b, "~ update %s (version %d)\n", c.ID, c.Version)
+		case ActionDelete:
+			fmt.Fprintf(&b, "- delete %s (version %d)\n", c.ID, c.Version)
+		}
+		for _, d := range c.Diffs {
+			fmt.Fprintf(&b, "    %s: %s -> %s\n", d.Field, formatValue(d.Old), formatValue(d.New))
+		}
+	}
+	fmt.Fprintf(&b, "plan: %d to create, %d to update, %d to delete", counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete])
+	return b.String()
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "(unset)"
+	}
+	encoded, _ := json.Marshal(v)
+	return string(encoded)
+}
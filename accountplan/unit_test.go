@@ -0,0 +1,161 @@
+//go:build unit
+// +build unit
+
+package accountplan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.form3-client.com/account"
+)
+
+const (
+	keptID    = "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	newID     = "bd27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	changedID = "cd27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	strayID   = "dd27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	otherID   = "ed27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	orgID     = "eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"
+	otherOrg  = "fb0bd6f5-c3f5-44b2-b677-acd23cdde73c"
+)
+
+// fakeClient keeps the accounts in a map and checks the versions like the API does
+type fakeClient struct {
+	accounts map[string]*account.AccountData
+	patches  map[string]*account.AccountPatch
+}
+
+func (f *fakeClient) GetAccounts(ctx context.Context, ids []string, opts account.BulkOptions) (map[string]account.BulkResult, account.BulkSummary) {
+	results := make(map[string]account.BulkResult)
+	for _, id := range ids {
+		if acc, ok := f.accounts[id]; ok {
+			results[id] = account.BulkResult{Account: acc, Outcome: account.OutcomeSucceeded}
+		} else {
+			results[id] = account.BulkResult{Err: &account.ResponseError{StatusCode: 404}, Outcome: account.OutcomeNotFound}
+		}
+	}
+	return results, account.BulkSummary{}
+}
+
+func (f *fakeClient) WalkAccounts(ctx context.Context, pageSize int, fn func(*account.AccountData) error) error {
+	for _, acc := range f.accounts {
+		if err := fn(acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeClient) CreateAccount(ctx context.Context, acc *account.AccountData) (*account.AccountData, error) {
+	f.accounts[acc.ID] = acc
+	return acc, nil
+}
+
+func (f *fakeClient) PatchAccount(ctx context.Context, id string, version int64, patch *account.AccountPatch) (*account.AccountData, error) {
+	if f.accounts[id].Version != version {
+		return nil, &account.ResponseError{StatusCode: 409, Message: "invalid version"}
+	}
+	f.patches[id] = patch
+	f.accounts[id].Version++
+	return f.accounts[id], nil
+}
+
+func (f *fakeClient) DeleteAccount(ctx context.Context, id string, version int64) error {
+	if f.accounts[id].Version != version {
+		return &account.ResponseError{StatusCode: 409, Message: "invalid version"}
+	}
+	delete(f.accounts, id)
+	return nil
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		accounts: map[string]*account.AccountData{
+			keptID:    {ID: keptID, OrganisationID: orgID, Type: "accounts", Attributes: &account.AccountAttributes{Country: "GB"}},
+			changedID: {ID: changedID, OrganisationID: orgID, Type: "accounts", Version: 2, Attributes: &account.AccountAttributes{Country: "GB", Bic: "NWBKGB22", Switched: true}},
+			strayID:   {ID: strayID, OrganisationID: orgID, Type: "accounts", Version: 1, Attributes: &account.AccountAttributes{Country: "RO"}},
+			otherID:   {ID: otherID, OrganisationID: otherOrg, Type: "accounts", Attributes: &account.AccountAttributes{Country: "RO"}},
+		},
+		patches: make(map[string]*account.AccountPatch),
+	}
+}
+
+var manifestYAML = fmt.Sprintf(`
+accounts:
+  - id: %s
+    organisation_id: %s
+    attributes:
+      country: GB
+  - id: %s
+    organisation_id: %s
+    attributes:
+      country: FR
+      name: [Jane, Doe]
+  - id: %s
+    organisation_id: %s
+    attributes:
+      country: FR
+      bic: NWBKGB22
+`, keptID, orgID, newID, orgID, changedID, orgID)
+
+// The plan lists the creates, updates and deletes with the fields that change
+func TestComputeAndApply(t *testing.T) {
+	// WHEN
+	manifest, err := ReadManifest(strings.NewReader(manifestYAML))
+	assert.Nil(t, err)
+	client := newFakeClient()
+
+	// THEN
+	plan, err := Compute(context.Background(), client, manifest, Options{Prune: true})
+	assert.Nil(t, err)
+	assert.Equal(t, `+ create `+newID+`
+    country: (unset) -> "FR"
+    name: (unset) -> ["Jane","Doe"]
+~ update `+changedID+` (version 2)
+    country: "GB" -> "FR"
+    switched: true -> (unset)
+- delete `+strayID+` (version 1)
+    country: "RO" -> (unset)
+plan: 1 to create, 1 to update, 1 to delete`, plan.String())
+
+	result, err := Apply(context.Background(), client, plan)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Failed)
+	assert.Equal(t, account.AccountPatch{Country: account.Set("FR"), Switched: account.Null[bool]()}, *client.patches[changedID])
+	assert.NotContains(t, client.accounts, strayID)
+	assert.Contains(t, client.accounts, otherID, "other organisations are left alone")
+	assert.Contains(t, client.accounts, newID)
+
+	client.accounts[changedID].Attributes = &account.AccountAttributes{Country: "FR", Bic: "NWBKGB22"}
+	plan, err = Compute(context.Background(), client, manifest, Options{Prune: true})
+	assert.Nil(t, err)
+	assert.True(t, plan.IsEmpty())
+}
+
+// Records touched between plan and apply fail with a conflict rather than being overwritten
+func TestApplyReportsVersionConflicts(t *testing.T) {
+	// WHEN
+	manifest, _ := ReadManifest(strings.NewReader(manifestYAML))
+	client := newFakeClient()
+	plan, _ := Compute(context.Background(), client, manifest, Options{})
+	client.accounts[changedID].Version++
+
+	// THEN
+	result, err := Apply(context.Background(), client, plan)
+	assert.EqualError(t, err, "1 of 2 changes failed")
+	assert.Equal(t, "response status code 409 with error message: invalid version", result.Results[1].Error)
+}
+
+// Manifests are validated before anything is compared
+func TestReadManifestRejectsInvalidAccounts(t *testing.T) {
+	_, err := ReadManifest(strings.NewReader("accounts:\n  - id: nope\n"))
+	assert.EqualError(t, err, `account 0 of the manifest: id in body must be of type uuid: "nope"`)
+
+	duplicate := fmt.Sprintf("accounts:\n  - {id: %s, organisation_id: %s, attributes: {country: GB}}\n  - {id: %s, organisation_id: %s, attributes: {country: GB}}\n", keptID, orgID, keptID, orgID)
+	_, err = ReadManifest(strings.NewReader(duplicate))
+	assert.EqualError(t, err, "account "+keptID+" is listed twice in the manifest")
+}
@@ -0,0 +1,241 @@
+package accounttest
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.form3-client.com/account"
+)
+
+type FaultKind string
+
+const (
+	// FaultLatency delays the request and lets the next faults of the rule roll
+	FaultLatency FaultKind = "latency"
+	// FaultTooManyRequests answers 429 with a Retry-After header
+	FaultTooManyRequests FaultKind = "too_many_requests"
+	// FaultServerError answers a 5xx with a JSON error body
+	FaultServerError FaultKind = "server_error"
+	// FaultConnectionReset resets the connection without answering
+	FaultConnectionReset FaultKind = "connection_reset"
+	// FaultTruncatedBody serves the real response but closes the connection half way through the body
+	FaultTruncatedBody FaultKind = "truncated_body"
+	// FaultMalformedJSON serves the real status and headers with a body that is not valid JSON
+	FaultMalformedJSON FaultKind = "malformed_json"
+	// FaultHTMLError answers a 5xx with the HTML page a proxy in front of the API would send
+	FaultHTMLError FaultKind = "html_error"
+)
+
+// Fault is one failure a rule can inject. The request still reaches the store for the truncated body and
+// the malformed JSON faults, like a response lost on its way back; the others answer before it does.
+type Fault struct {
+	Kind        FaultKind
+	Probability float64       // from 0, never, to 1, always
+	Times       int           // the fault is injected at most this many times, 0 means no limit
+	Latency     time.Duration // FaultLatency
+	StatusCode  int           // FaultServerError and FaultHTMLError, 503 and 502 by default
+	RetryAfter  time.Duration // FaultTooManyRequests, 1 second by default
+}
+
+// Rule applies its faults to the requests of the given operations, or to all the account routes when empty.
+// The faults roll in order: latencies add up and the first other fault that fires answers the request.
+type Rule struct {
+	Operations []account.Operation
+	Faults     []Fault
+}
+
+// FaultInjector wraps the fake API and breaks some of its responses. It draws from a rand seeded by the
+// caller, so a test sending its requests one after the other sees the same failures on every run.
+type FaultInjector struct {
+	next http.Handler
+
+	mu       sync.Mutex // guards the rand, the rules and the counts
+	rand     *rand.Rand
+	rules    []Rule
+	injected map[FaultKind]int
+	fired    map[*Fault]int
+}
+
+func NewFaultInjector(next http.Handler, seed int64, rules ...Rule) *FaultInjector {
+	return &FaultInjector{
+		next:     next,
+		rand:     rand.New(rand.NewSource(seed)),
+		rules:    rules,
+		injected: make(map[FaultKind]int),
+		fired:    make(map[*Fault]int),
+	}
+}
+
+// NewFaultyServer starts a fake API backed by a MemoryStore with the given faults
+func NewFaultyServer(seed int64, rules ...Rule) *Server {
+	store := NewMemoryStore()
+	faults := NewFaultInjector(NewHandler(store), seed, rules...)
+	return startServer(faults, store, faults)
+}
+
+// Injected counts how many times a kind of fault was injected
+func (f *FaultInjector) Injected(kind FaultKind) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected[kind]
+}
+
+// operation names the route of a request the way the client does, it is empty outside the account routes
+func operation(r *http.Request) account.Operation {
+	switch {
+	case r.URL.Path == accountsPath && r.Method == http.MethodPost:
+		return account.OperationCreate
+	case r.URL.Path == accountsPath && r.Method == http.MethodGet:
+		return account.OperationList
+	case strings.HasPrefix(r.URL.Path, accountsPath+"/"):
+		switch r.Method {
+		case http.MethodGet:
+			return account.OperationGet
+		case http.MethodPatch:
+			return account.OperationUpdate
+		case http.MethodDelete:
+			return account.OperationDelete
+		}
+	}
+	return ""
+}
+
+func (rule *Rule) matches(op account.Operation) bool {
+	if op == "" {
+		return false
+	}
+	if len(rule.Operations) == 0 {
+		return true
+	}
+	for _, candidate := range rule.Operations {
+		if candidate == op {
+			return true
+		}
+	}
+	return false
+}
+
+// roll decides the faults of a request: the total latency and the fault that answers it, if any
+func (f *FaultInjector) roll(op account.Operation) (time.Duration, *Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var latency time.Duration
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if !rule.matches(op) {
+			continue
+		}
+		for j := range rule.Faults {
+			fault := &rule.Faults[j]
+			if fault.Times > 0 && f.fired[fault] >= fault.Times {
+				continue
+			}
+			if f.rand.Float64() >= fault.Probability {
+				continue
+			}
+			f.fired[fault]++
+			f.injected[fault.Kind]++
+			if fault.Kind != FaultLatency {
+				return latency, fault
+			}
+			latency += fault.Latency
+		}
+	}
+	return latency, nil
+}
+
+func (f *FaultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	latency, fault := f.roll(operation(r))
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+	if fault == nil {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	switch fault.Kind {
+	case FaultTooManyRequests:
+		retryAfter := fault.RetryAfter
+		if retryAfter == 0 {
+			retryAfter = time.Second
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second)/time.Second)))
+		writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+	case FaultServerError:
+		writeError(w, statusOr(fault.StatusCode, http.StatusServiceUnavailable), "injected server error")
+	case FaultHTMLError:
+		status := statusOr(fault.StatusCode, http.StatusBadGateway)
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<html>\r\n<head><title>%[1]d %[2]s</title></head>\r\n<body>\r\n<center><h1>%[1]d %[2]s</h1></center>\r\n</body>\r\n</html>\r\n", status, http.StatusText(status))
+	case FaultConnectionReset:
+		resetConnection(w)
+	case FaultTruncatedBody:
+		recorder := httptest.NewRecorder()
+		f.next.ServeHTTP(recorder, r)
+		writeTruncated(w, recorder)
+	case FaultMalformedJSON:
+		recorder := httptest.NewRecorder()
+		f.next.ServeHTTP(recorder, r)
+		body := recorder.Body.Bytes()
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(body[:len(body)/2])
+	}
+}
+
+func statusOr(status, fallback int) int {
+	if status == 0 {
+		return fallback
+	}
+	return status
+}
+
+// resetConnection closes the connection with a RST rather than a FIN, which is what clients see when a
+// load balancer drops them
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// writeTruncated announces the full length of the recorded body, sends half of it and closes the connection
+func writeTruncated(w http.ResponseWriter, recorder *httptest.ResponseRecorder) {
+	conn, buffered, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	body := recorder.Body.Bytes()
+	header := recorder.Header()
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Connection", "close")
+	fmt.Fprintf(buffered, "HTTP/1.1 %d %s\r\n", recorder.Code, http.StatusText(recorder.Code))
+	header.Write(buffered)
+	buffered.WriteString("\r\n")
+	buffered.Write(body[:len(body)/2])
+	buffered.Flush()
+}
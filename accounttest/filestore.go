@@ -0,0 +1,202 @@
+package accounttest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.form3-client.com/account"
+)
+
+// FileStore is a MemoryStore that appends every change to a JSONL file before making it: a stored account is a
+// line of its own and a deleted one a {"deleted": id} line. The file is compacted to one line per account when
+// opened, by writing a temporary file and renaming it over the old one, so a crash never leaves it half written.
+type FileStore struct {
+	*MemoryStore
+	path string
+	mu   sync.Mutex // serialises the writes to the file
+	file *os.File   // the changes are appended to
+	size int64      // of the file up to the last change written whole
+}
+
+// fileRecord is a line of the file, either an account or the id of a deleted one
+type fileRecord struct {
+	*account.AccountData
+	Deleted string `json:"deleted,omitempty"`
+}
+
+// OpenFileStore loads the accounts from path, a missing file is an empty store
+func OpenFileStore(path string) (*FileStore, error) {
+	store := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	file, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = replay(store.MemoryStore, file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not load %s: %w", path, err)
+		}
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileStore) Put(acc *account.AccountData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(fileRecord{AccountData: acc}); err != nil {
+		return err
+	}
+	return s.MemoryStore.Put(acc)
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.MemoryStore.Get(id); !ok {
+		return nil
+	}
+	if err := s.append(fileRecord{Deleted: id}); err != nil {
+		return err
+	}
+	return s.MemoryStore.Delete(id)
+}
+
+// Seed stores the accounts read from JSONL like the Seed function does
+func (s *FileStore) Seed(r io.Reader) (int, error) {
+	return Seed(s, r)
+}
+
+// Close closes the file, the accounts are all in it already
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// append writes a change as one line, and cuts the file back to its last whole line when that fails, so that the
+// file and the accounts in memory never disagree
+func (s *FileStore) append(record fileRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not persist the change: %w", err)
+	}
+	written, err := s.file.Write(append(line, '\n'))
+	if err != nil {
+		if written > 0 {
+			s.file.Truncate(s.size)
+		}
+		return fmt.Errorf("could not persist the change: %w", err)
+	}
+	s.size += int64(written)
+	return nil
+}
+
+// compact rewrites the file with a line per account and opens it for the changes to come
+func (s *FileStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not compact the accounts: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, acc := range s.MemoryStore.List() {
+		if err := encoder.Encode(acc); err != nil {
+			tmp.Close()
+			return fmt.Errorf("could not compact the accounts: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not compact the accounts: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not compact the accounts: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("could not compact the accounts: %w", err)
+	}
+	if s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		return err
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		s.file.Close()
+		return err
+	}
+	s.size = info.Size()
+	return nil
+}
+
+// replay applies the lines of the file in order. The last line is dropped when it does not decode, as it is a
+// change a crash cut short before it was made.
+func replay(store *MemoryStore, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var torn error
+	for line := 1; scanner.Scan(); line++ {
+		if torn != nil {
+			return torn
+		}
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record fileRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			torn = fmt.Errorf("line %d: %w", line, err)
+			continue
+		}
+		if record.Deleted != "" {
+			store.Delete(record.Deleted)
+			continue
+		}
+		if record.AccountData == nil {
+			return fmt.Errorf("line %d: neither an account nor a deleted id", line)
+		}
+		store.Put(record.AccountData)
+	}
+	return scanner.Err()
+}
+
+// Seed stores the accounts read from JSONL, one AccountData per line, and returns how many it stored. The
+// accounts are validated like the API would and a missing type defaults to "accounts".
+func Seed(store Store, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line, seeded := 0, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var acc account.AccountData
+		if err := json.Unmarshal([]byte(text), &acc); err != nil {
+			return seeded, fmt.Errorf("line %d: %w", line, err)
+		}
+		if acc.Type == "" {
+			acc.Type = "accounts"
+		}
+		if err := acc.Validate(); err != nil {
+			return seeded, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := store.Put(&acc); err != nil {
+			return seeded, fmt.Errorf("line %d: %w", line, err)
+		}
+		seeded++
+	}
+	return seeded, scanner.Err()
+}
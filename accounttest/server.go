@@ -0,0 +1,326 @@
+// Package accounttest provides an in-memory fake of the account API for tests. It serves the same routes as
+// the real API, checks versions the same way and answers with the same validation messages.
+package accounttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"go.form3-client.com/account"
+)
+
+const (
+	accountsPath   = "/v1/organisation/accounts"
+	healthPath     = "/v1/health"
+	contentType    = "application/vnd.api+json"
+	maxPageSize    = 1000
+	validationList = "validation failure list:\n"
+)
+
+// Server is a started fake; point an account.AccountClient at its URL
+type Server struct {
+	conns int64 // first, for the alignment of atomic operations on 32 bit platforms
+
+	*httptest.Server
+	Store  Store
+	Faults *FaultInjector // nil unless started by NewFaultyServer
+}
+
+// NewServer starts a fake API backed by a MemoryStore
+func NewServer() *Server {
+	store := NewMemoryStore()
+	return startServer(NewHandler(store), store, nil)
+}
+
+func startServer(handler http.Handler, store Store, faults *FaultInjector) *Server {
+	server := &Server{Server: httptest.NewUnstartedServer(handler), Store: store, Faults: faults}
+	server.Config.ConnState = server.trackConn
+	server.Start()
+	return server
+}
+
+func (s *Server) trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&s.conns, 1)
+	case http.StateClosed, http.StateHijacked: // hijacked connections are closed by the faults that take them
+		atomic.AddInt64(&s.conns, -1)
+	}
+}
+
+// OpenConnections counts the client connections the server holds, idle keep-alive ones included
+func (s *Server) OpenConnections() int {
+	return int(atomic.LoadInt64(&s.conns))
+}
+
+// handler serves the account routes over a Store
+type handler struct {
+	mu    sync.Mutex // creates, patches and deletes check and write the store in one step
+	store Store
+}
+
+// NewHandler serves the account API from the given store
+func NewHandler(store Store) http.Handler {
+	return &handler{store: store}
+}
+
+type errorBody struct {
+	ErrorMessage string `json:"error_message"`
+}
+
+type dataBody struct {
+	Data interface{} `json:"data"`
+}
+
+type listBody struct {
+	Data  []*account.AccountData `json:"data"`
+	Links account.ListLinks      `json:"links"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorBody{ErrorMessage: message})
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == healthPath:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "up"})
+	case r.URL.Path == accountsPath:
+		switch r.Method {
+		case http.MethodPost:
+			h.create(w, r)
+		case http.MethodGet:
+			h.list(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case strings.HasPrefix(r.URL.Path, accountsPath+"/"):
+		id := strings.TrimPrefix(r.URL.Path, accountsPath+"/")
+		if _, err := uuid.Parse(id); err != nil {
+			writeError(w, http.StatusBadRequest, "id is not a valid uuid")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.get(w, id)
+		case http.MethodDelete:
+			h.delete(w, r, id)
+		case http.MethodPatch:
+			h.patch(w, r, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// validationMessage nests the message the way the API reports it, one list per level of the document
+func validationMessage(err error) string {
+	var validationErr *account.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err.Error()
+	}
+	levels := 2
+	if validationErr.Attribute {
+		levels = 3
+	}
+	return strings.Repeat(validationList, levels) + validationErr.Error()
+}
+
+func (h *handler) create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Data *account.AccountData `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %s", err))
+		return
+	}
+	if body.Data == nil {
+		writeError(w, http.StatusBadRequest, validationList+"data in body is required")
+		return
+	}
+	if err := body.Data.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, validationMessage(err))
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.store.Get(body.Data.ID); exists {
+		writeError(w, http.StatusConflict, "Account cannot be created as it violates a duplicate constraint")
+		return
+	}
+	body.Data.Version = 0
+	if err := h.store.Put(body.Data); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, dataBody{body.Data})
+}
+
+func (h *handler) get(w http.ResponseWriter, id string) {
+	acc, ok := h.store.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("record %s does not exist", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, dataBody{acc})
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	number, size := 0, account.DefaultPageSize
+	var err error
+	if raw := query.Get("page[number]"); raw != "" {
+		if number, err = strconv.Atoi(raw); err != nil || number < 0 {
+			writeError(w, http.StatusBadRequest, "invalid page number")
+			return
+		}
+	}
+	if raw := query.Get("page[size]"); raw != "" {
+		if size, err = strconv.Atoi(raw); err != nil || size < 1 || size > maxPageSize {
+			writeError(w, http.StatusBadRequest, "invalid page size")
+			return
+		}
+	}
+
+	accounts := h.store.List()
+	pageLink := func(n int) string {
+		return fmt.Sprintf("%s?page%%5Bnumber%%5D=%d&page%%5Bsize%%5D=%d", accountsPath, n, size)
+	}
+	last := 0
+	if len(accounts) > 0 {
+		last = (len(accounts) - 1) / size
+	}
+	body := listBody{Data: make([]*account.AccountData, 0, size), Links: account.ListLinks{
+		First: pageLink(0), Last: pageLink(last), Self: pageLink(number),
+	}}
+	if number < last {
+		body.Links.Next = pageLink(number + 1)
+	}
+	if number > 0 {
+		body.Links.Prev = pageLink(number - 1)
+	}
+	for i := number * size; i < len(accounts) && i < (number+1)*size; i++ {
+		body.Data = append(body.Data, accounts[i])
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	version, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid version number")
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	acc, ok := h.store.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("record %s does not exist", id))
+		return
+	}
+	if acc.Version != version {
+		writeError(w, http.StatusConflict, "invalid version")
+		return
+	}
+	if err := h.store.Delete(id); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patch applies the attributes of the body as a JSON merge patch: null clears an attribute
+func (h *handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	var body struct {
+		Data *struct {
+			ID         string                     `json:"id"`
+			Version    *int64                     `json:"version"`
+			Attributes map[string]json.RawMessage `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Data == nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.Data.ID != id {
+		writeError(w, http.StatusBadRequest, "id in body does not match the url")
+		return
+	}
+	if body.Data.Version == nil {
+		writeError(w, http.StatusBadRequest, validationList+validationList+"version in body is required")
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	acc, ok := h.store.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("record %s does not exist", id))
+		return
+	}
+	if acc.Version != *body.Data.Version {
+		writeError(w, http.StatusConflict, "invalid version")
+		return
+	}
+
+	patched, err := mergeAttributes(acc.Attributes, body.Data.Attributes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	acc.Attributes = patched
+	if err := acc.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, validationMessage(err))
+		return
+	}
+	acc.Version++
+	if err := h.store.Put(acc); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, dataBody{acc})
+}
+
+func mergeAttributes(current *account.AccountAttributes, patch map[string]json.RawMessage) (*account.AccountAttributes, error) {
+	merged := make(map[string]json.RawMessage)
+	if current != nil {
+		encoded, _ := json.Marshal(current)
+		json.Unmarshal(encoded, &merged)
+	}
+	for key, value := range patch {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	encoded, _ := json.Marshal(merged)
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var attributes account.AccountAttributes
+	if err := decoder.Decode(&attributes); err != nil {
+		return nil, fmt.Errorf("invalid attributes: %w", err)
+	}
+	return &attributes, nil
+}
@@ -0,0 +1,83 @@
+package accounttest
+
+import (
+	"encoding/json"
+	"sync"
+
+	"go.form3-client.com/account"
+)
+
+// Store keeps the accounts of the fake API. Implementations must be safe for concurrent use and must not
+// hand out accounts that callers can change behind their back.
+type Store interface {
+	Get(id string) (*account.AccountData, bool)
+	Put(acc *account.AccountData) error
+	Delete(id string) error
+	// List returns the accounts in the order they were first stored, which keeps the pages stable
+	List() []*account.AccountData
+}
+
+// MemoryStore is the default Store of the fake server
+type MemoryStore struct {
+	mu       sync.RWMutex
+	accounts map[string]*account.AccountData
+	order    []string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{accounts: make(map[string]*account.AccountData)}
+}
+
+func (s *MemoryStore) Get(id string) (*account.AccountData, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	acc, ok := s.accounts[id]
+	if !ok {
+		return nil, false
+	}
+	return clone(acc), true
+}
+
+func (s *MemoryStore) Put(acc *account.AccountData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[acc.ID]; !ok {
+		s.order = append(s.order, acc.ID)
+	}
+	s.accounts[acc.ID] = clone(acc)
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[id]; !ok {
+		return nil
+	}
+	delete(s.accounts, id)
+	for i, stored := range s.order {
+		if stored == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) List() []*account.AccountData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	accounts := make([]*account.AccountData, len(s.order))
+	for i, id := range s.order {
+		accounts[i] = clone(s.accounts[id])
+	}
+	return accounts
+}
+
+// clone deep copies an account through its json form, which is all the API knows of it anyway
+func clone(acc *account.AccountData) *account.AccountData {
+	encoded, _ := json.Marshal(acc)
+	var copied account.AccountData
+	json.Unmarshal(encoded, &copied)
+	return &copied
+}
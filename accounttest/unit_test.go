@@ -0,0 +1,389 @@
+//go:build unit
+// +build unit
+
+package accounttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.form3-client.com/account"
+)
+
+func newClient(t *testing.T, server *Server) *account.AccountClient {
+	// strict, so that the fake does not drift from the models either
+	client, err := account.NewAccountClientWithOptions(server.URL, &http.Client{Timeout: account.ClientTimeout}, account.ClientOptions{Strict: true})
+	require.NoError(t, err)
+	return client
+}
+
+func newAccount() *account.AccountData {
+	return &account.AccountData{
+		ID:             uuid.New().String(),
+		OrganisationID: uuid.New().String(),
+		Type:           "accounts",
+		Attributes:     &account.AccountAttributes{Country: "GB", Name: []string{"John", "Doe"}},
+	}
+}
+
+// The fake answers with the messages the integration tests expect from the real API
+func TestCreateValidationMessages(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+
+	cases := []struct {
+		name     string
+		mutate   func(a *account.AccountData)
+		expected string
+	}{
+		{"account type is required", func(a *account.AccountData) { a.Type = "invalid type" },
+			"validation failure list:\nvalidation failure list:\ntype in body should be one of [accounts]"},
+		{"country attribute is required", func(a *account.AccountData) { a.Attributes.Country = "" },
+			"validation failure list:\nvalidation failure list:\nvalidation failure list:\ncountry in body is required"},
+		{"invalid account id", func(a *account.AccountData) { a.ID = "invalid-id" },
+			"validation failure list:\nvalidation failure list:\nid in body must be of type uuid: \"invalid-id\""},
+		{"country is validated", func(a *account.AccountData) { a.Attributes.Country = "invalid" },
+			"validation failure list:\nvalidation failure list:\nvalidation failure list:\ncountry in body should match '^[A-Z]{2}$'"},
+		{"bank id code is validated", func(a *account.AccountData) { a.Attributes.BankIDCode = "WRONGID121212" },
+			"validation failure list:\nvalidation failure list:\nvalidation failure list:\nbank_id_code in body should match '^[A-Z]{0,16}$'"},
+		{"bic code is validated", func(a *account.AccountData) { a.Attributes.Bic = "WRONGBIC123213" },
+			"validation failure list:\nvalidation failure list:\nvalidation failure list:\nbic in body should match '^([A-Z]{6}[A-Z0-9]{2}|[A-Z]{6}[A-Z0-9]{5})$'"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// WHEN
+			data := newAccount()
+			tc.mutate(data)
+
+			// THEN
+			_, err := client.CreateAccount(context.Background(), data)
+			assert.EqualError(t, err, "response status code 400 with error message: "+tc.expected)
+		})
+	}
+}
+
+// Create, fetch, patch and delete go through the version checks of the API
+func TestAccountLifecycle(t *testing.T) {
+	// WHEN
+	server := NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	ctx := context.Background()
+	data := newAccount()
+
+	// THEN
+	created, err := client.CreateAccount(ctx, data)
+	assert.Nil(t, err)
+	assert.Equal(t, data, created)
+
+	_, err = client.CreateAccount(ctx, data)
+	assert.EqualError(t, err, "response status code 409 with error message: Account cannot be created as it violates a duplicate constraint")
+
+	fetched, err := client.GetById(ctx, data.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, data, fetched)
+
+	patched, err := client.PatchAccount(ctx, data.ID, 0, &account.AccountPatch{Country: account.Set("FR"), Name: account.Null[[]string]()})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), patched.Version)
+	assert.Equal(t, &account.AccountAttributes{Country: "FR"}, patched.Attributes)
+
+	_, err = client.PatchAccount(ctx, data.ID, 0, &account.AccountPatch{Country: account.Set("RO")})
+	assert.ErrorIs(t, err, account.ErrConflict)
+	_, err = client.PatchAccount(ctx, data.ID, 1, &account.AccountPatch{Country: account.Null[string]()})
+	assert.EqualError(t, err, "response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\ncountry in body is required")
+
+	assert.ErrorIs(t, client.DeleteAccount(ctx, data.ID, 0), account.ErrConflict)
+	assert.Nil(t, client.DeleteAccount(ctx, data.ID, 1))
+	_, err = client.GetById(ctx, data.ID)
+	assert.EqualError(t, err, fmt.Sprintf("response status code 404 with error message: record %s does not exist", data.ID))
+
+	other := newAccount()
+	server.Store.Put(other)
+	updated, err := client.UpdateAccount(ctx, other) // version 0 is sent too
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), updated.Version)
+}
+
+// Pages keep the insertion order and the links point to their neighbours
+func TestListPages(t *testing.T) {
+	// WHEN
+	server := NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		data := newAccount()
+		server.Store.Put(data)
+		ids = append(ids, data.ID)
+	}
+
+	// THEN
+	page, err := client.ListAccounts(ctx, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, ids[2], page.Accounts[0].ID)
+	assert.Equal(t, ids[3], page.Accounts[1].ID)
+	assert.Equal(t, "/v1/organisation/accounts?page%5Bnumber%5D=2&page%5Bsize%5D=2", page.Links.Next)
+
+	var walked []string
+	assert.Nil(t, client.WalkAccounts(ctx, 2, func(a *account.AccountData) error {
+		walked = append(walked, a.ID)
+		return nil
+	}))
+	assert.Equal(t, ids, walked)
+}
+
+// Requests the client would refuse to send are still answered like the API does
+func TestRawRequests(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/organisation/accounts/not-a-uuid")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.JSONEq(t, `{"error_message": "id is not a valid uuid"}`, string(body))
+
+	data := newAccount()
+	server.Store.Put(data)
+	patch := fmt.Sprintf(`{"data": {"id": "%s", "type": "accounts", "attributes": {"country": "FR"}}}`, data.ID)
+	request, _ := http.NewRequest(http.MethodPatch, server.URL+"/v1/organisation/accounts/"+data.ID, strings.NewReader(patch))
+	resp, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.JSONEq(t, `{"error_message": "validation failure list:\nvalidation failure list:\nversion in body is required"}`, string(body))
+
+	resp, err = http.Get(server.URL + "/v1/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/vnd.api+json", resp.Header.Get("Content-Type"))
+}
+
+// The file store survives a restart and is seeded from JSONL
+func TestFileStore(t *testing.T) {
+	// WHEN
+	path := filepath.Join(t.TempDir(), "accounts.jsonl")
+	store, err := OpenFileStore(path)
+	require.NoError(t, err)
+	seeded := newAccount()
+	encoded, _ := json.Marshal(seeded)
+	n, err := store.Seed(strings.NewReader(string(encoded) + "\n\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	server := &Server{Server: httptest.NewServer(NewHandler(store)), Store: store}
+	client := newClient(t, server)
+	created, err := client.CreateAccount(context.Background(), newAccount())
+	require.NoError(t, err)
+	_, err = client.PatchAccount(context.Background(), seeded.ID, 0, &account.AccountPatch{Country: account.Set("FR")})
+	require.NoError(t, err)
+	server.Close()
+	require.NoError(t, store.Close())
+
+	// THEN
+	reopened, err := OpenFileStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	accounts := reopened.List()
+	require.Len(t, accounts, 2)
+	assert.Equal(t, seeded.ID, accounts[0].ID)
+	assert.Equal(t, int64(1), accounts[0].Version)
+	assert.Equal(t, "FR", accounts[0].Attributes.Country)
+	assert.Equal(t, created, accounts[1])
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+
+	_, err = Seed(NewMemoryStore(), strings.NewReader(`{"id":"not-a-uuid"}`))
+	assert.EqualError(t, err, `line 1: id in body must be of type uuid: "not-a-uuid"`)
+}
+
+// Every change is a line appended to the file, which is compacted when opened; a change cut short by a crash is
+// dropped and a change that could not be written is not made
+func TestFileStoreAppendsTheChanges(t *testing.T) {
+	// WHEN
+	path := filepath.Join(t.TempDir(), "accounts.jsonl")
+	store, err := OpenFileStore(path)
+	require.NoError(t, err)
+	first, second := newAccount(), newAccount()
+	require.NoError(t, store.Put(first))
+	require.NoError(t, store.Put(second))
+	first.Version++
+	require.NoError(t, store.Put(first))
+	require.NoError(t, store.Delete(second.ID))
+	require.NoError(t, store.Delete(second.ID))
+	require.NoError(t, store.Close())
+
+	// THEN
+	lines := func() []string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+	assert.Len(t, lines(), 4, "a line per change, a delete of a missing account is none")
+	assert.Equal(t, `{"deleted":"`+second.ID+`"}`, lines()[3])
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	file.WriteString(`{"id":"` + second.ID)
+	file.Close()
+	reopened, err := OpenFileStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, []*account.AccountData{first}, reopened.List())
+	assert.Len(t, lines(), 1, "compacted to a line per account")
+
+	reopened.file.Close() // every write fails from now on
+	assert.Error(t, reopened.Put(second))
+	assert.Error(t, reopened.Delete(first.ID))
+	assert.Equal(t, []*account.AccountData{first}, reopened.List(), "the changes that could not be written are not made")
+
+	file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	file.WriteString("garbage\n" + `{"deleted":"` + first.ID + `"}` + "\n")
+	file.Close()
+	_, err = OpenFileStore(path)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+// The same seed injects the same faults in the same order
+func TestFaultsAreSeeded(t *testing.T) {
+	rules := []Rule{{Faults: []Fault{
+		{Kind: FaultLatency, Probability: 0.5, Latency: time.Millisecond},
+		{Kind: FaultServerError, Probability: 0.3},
+	}}}
+	sequence := func(seed int64) []string {
+		injector := NewFaultInjector(http.NotFoundHandler(), seed, rules...)
+		var rolled []string
+		for i := 0; i < 50; i++ {
+			latency, fault := injector.roll(account.OperationGet)
+			kind := FaultKind("none")
+			if fault != nil {
+				kind = fault.Kind
+			}
+			rolled = append(rolled, fmt.Sprintf("%v/%s", latency, kind))
+		}
+		return rolled
+	}
+
+	// WHEN
+	first, second := sequence(42), sequence(42)
+
+	// THEN
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, sequence(43))
+	assert.Contains(t, first, "1ms/server_error")
+	assert.Contains(t, first, "0s/none")
+}
+
+// Rules only apply to their operations and stop after their number of times
+func TestFaultRulesMatchOperations(t *testing.T) {
+	injector := NewFaultInjector(http.NotFoundHandler(), 1, Rule{
+		Operations: []account.Operation{account.OperationCreate},
+		Faults:     []Fault{{Kind: FaultServerError, Probability: 1, Times: 2}},
+	})
+
+	// WHEN
+	_, get := injector.roll(account.OperationGet)
+	_, health := injector.roll("")
+	_, first := injector.roll(account.OperationCreate)
+	_, second := injector.roll(account.OperationCreate)
+	_, third := injector.roll(account.OperationCreate)
+
+	// THEN
+	assert.Nil(t, get)
+	assert.Nil(t, health)
+	assert.NotNil(t, first)
+	assert.NotNil(t, second)
+	assert.Nil(t, third)
+	assert.Equal(t, 2, injector.Injected(FaultServerError))
+}
+
+// Rate limits, proxy error pages and dropped connections are retried until the request gets through
+func TestClientRetriesInjectedFaults(t *testing.T) {
+	// WHEN
+	server := NewFaultyServer(1, Rule{
+		Operations: []account.Operation{account.OperationGet},
+		Faults: []Fault{
+			{Kind: FaultTooManyRequests, Probability: 1, Times: 1},
+			{Kind: FaultHTMLError, Probability: 1, Times: 1},
+			{Kind: FaultConnectionReset, Probability: 1, Times: 1},
+		},
+	})
+	defer server.Close()
+	client := newClient(t, server)
+	data := newAccount()
+	server.Store.Put(data)
+
+	// THEN
+	fetched, err := client.GetById(context.Background(), data.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, data.ID, fetched.ID)
+	assert.Equal(t, 1, server.Faults.Injected(FaultTooManyRequests))
+	assert.Equal(t, 1, server.Faults.Injected(FaultHTMLError))
+	assert.Equal(t, 1, server.Faults.Injected(FaultConnectionReset))
+
+	resp, err := http.Get(server.URL + "/v1/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode, "the health route is never broken")
+}
+
+// Bodies cut short or garbled are reported as such rather than retried
+func TestClientReportsBrokenBodies(t *testing.T) {
+	cases := []struct {
+		kind     FaultKind
+		expected string
+	}{
+		{FaultTruncatedBody, "got an error while reading the response body: unexpected EOF"},
+		{FaultMalformedJSON, "unable to deserialize response body; error: unexpected end of JSON input"},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.kind), func(t *testing.T) {
+			// WHEN
+			server := NewFaultyServer(1, Rule{Faults: []Fault{{Kind: tc.kind, Probability: 1}}})
+			defer server.Close()
+			client := newClient(t, server)
+			data := newAccount()
+			server.Store.Put(data)
+
+			// THEN
+			_, err := client.GetById(context.Background(), data.ID)
+			assert.EqualError(t, err, tc.expected)
+			assert.Equal(t, 1, server.Faults.Injected(tc.kind))
+		})
+	}
+}
+
+// A slow API is abandoned when the caller's context ends
+func TestClientGivesUpOnInjectedLatency(t *testing.T) {
+	// WHEN
+	server := NewFaultyServer(1, Rule{Faults: []Fault{{Kind: FaultLatency, Probability: 1, Latency: time.Minute}}})
+	defer server.Close()
+	client := newClient(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// THEN
+	start := time.Now()
+	_, err := client.GetById(ctx, uuid.New().String())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,277 @@
+// Package cassette records the HTTP exchanges of a client to a file and replays them later, so tests written
+// against the real API can run offline.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ModeEnv is the environment variable tests read their Mode from
+const ModeEnv = "CASSETTE_MODE"
+
+type Mode string
+
+const (
+	ModeOff    Mode = ""       // requests go to the network and nothing is recorded
+	ModeRecord Mode = "record" // requests go to the network and are saved to the cassette
+	ModeReplay Mode = "replay" // requests are answered from the cassette and never reach the network
+)
+
+// ModeFromEnv reads the mode from ModeEnv, unset means ModeOff
+func ModeFromEnv() (Mode, error) {
+	mode := Mode(strings.TrimSpace(os.Getenv(ModeEnv)))
+	switch mode {
+	case ModeOff, ModeRecord, ModeReplay:
+		return mode, nil
+	default:
+		return ModeOff, fmt.Errorf("invalid %s %q, must be %s or %s", ModeEnv, mode, ModeRecord, ModeReplay)
+	}
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URI    string      `json:"uri"` // path and query, so a cassette replays against any host
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+func Load(path string) (*Cassette, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(encoded, &c); err != nil {
+		return nil, fmt.Errorf("could not parse the cassette %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette as indented JSON, creating its directory if needed
+func (c *Cassette) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not save the cassette: %w", err)
+	}
+	encoded, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not save the cassette: %w", err)
+	}
+	if err := os.WriteFile(path, append(encoded, '\n'), 0o644); err != nil {
+		return fmt.Errorf("could not save the cassette: %w", err)
+	}
+	return nil
+}
+
+// DefaultRedactedHeaders are replaced with Redacted in every recorded request and response
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+const Redacted = "REDACTED"
+
+type RecorderOptions struct {
+	// RedactHeaders are the headers whose values are not saved, DefaultRedactedHeaders when nil
+	RedactHeaders []string
+	// Redact is called on every interaction before it is kept, e.g. to blank fields of the bodies. MatchRequest
+	// compares the bodies of the requests with the recorded ones, so a cassette whose request bodies were
+	// redacted only replays with MatchInOrder.
+	Redact func(*Interaction)
+}
+
+// Recorder is a RoundTripper that sends the requests with the next one and keeps a copy of the exchanges
+type Recorder struct {
+	next http.RoundTripper
+	opts RecorderOptions
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder records the exchanges of next, http.DefaultTransport when nil
+func NewRecorder(next http.RoundTripper, opts RecorderOptions) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactedHeaders
+	}
+	return &Recorder{next: next, opts: opts}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if requestBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err // transport errors cannot be replayed, the test sees them while recording only
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := Interaction{
+		Request:  Request{Method: req.Method, URI: req.URL.RequestURI(), Header: r.redact(req.Header), Body: string(requestBody)},
+		Response: Response{StatusCode: resp.StatusCode, Header: r.redact(resp.Header), Body: string(responseBody)},
+	}
+	if r.opts.Redact != nil {
+		r.opts.Redact(&interaction)
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) redact(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range r.opts.RedactHeaders {
+		if _, ok := redacted[http.CanonicalHeaderKey(name)]; ok {
+			redacted.Set(name, Redacted)
+		}
+	}
+	return redacted
+}
+
+// Cassette returns the exchanges recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// Save writes the exchanges recorded so far to path
+func (r *Recorder) Save(path string) error {
+	return r.Cassette().Save(path)
+}
+
+// Match is how the Replayer picks the interaction that answers a request
+type Match string
+
+const (
+	// MatchInOrder answers with the next interaction and fails if its method and uri differ from the request
+	MatchInOrder Match = "in_order"
+	// MatchRequest answers with the first unused interaction of the same method, uri and body, which keeps
+	// working when concurrent requests reach the transport in another order than when they were recorded; the
+	// body is compared as recorded, after RecorderOptions.Redact
+	MatchRequest Match = "request"
+)
+
+// ErrNoInteraction is returned by the Replayer for requests the cassette does not answer
+var ErrNoInteraction = errors.New("no recorded interaction matches the request")
+
+// Replayer is a RoundTripper that answers the requests from a cassette; every interaction is used once
+type Replayer struct {
+	match Match
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+	next         int // the next interaction for MatchInOrder
+}
+
+func NewReplayer(c *Cassette, match Match) *Replayer {
+	return &Replayer{match: match, interactions: c.Interactions, used: make([]bool, len(c.Interactions))}
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	uri := req.URL.RequestURI()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	index := -1
+	switch r.match {
+	case MatchInOrder:
+		if r.next < len(r.interactions) {
+			recorded := r.interactions[r.next].Request
+			if recorded.Method != req.Method || recorded.URI != uri {
+				return nil, fmt.Errorf("%w: %s %s, interaction %d is %s %s", ErrNoInteraction, req.Method, uri, r.next, recorded.Method, recorded.URI)
+			}
+			index = r.next
+			r.next++
+		}
+	default:
+		for i, interaction := range r.interactions {
+			recorded := interaction.Request
+			if !r.used[i] && recorded.Method == req.Method && recorded.URI == uri && recorded.Body == string(body) {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, uri)
+	}
+	r.used[index] = true
+
+	recorded := r.interactions[index].Response
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Unused counts the interactions no request asked for yet
+func (r *Replayer) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unused := 0
+	for _, used := range r.used {
+		if !used {
+			unused++
+		}
+	}
+	return unused
+}
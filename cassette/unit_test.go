@@ -0,0 +1,136 @@
+//go:build unit
+// +build unit
+
+package cassette
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+// Recorded exchanges are saved redacted and replayed without the server
+func TestRecordAndReplay(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(200 + int(n))
+		io.WriteString(w, r.URL.Path+":"+string(body))
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	// WHEN
+	recorder := NewRecorder(nil, RecorderOptions{Redact: func(i *Interaction) {
+		i.Request.Body = strings.ReplaceAll(i.Request.Body, "password", "********")
+	}})
+	client := &http.Client{Transport: recorder}
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/login?user=john", strings.NewReader("password"))
+	request.Header.Set("Authorization", "Bearer token")
+	resp, err := client.Do(request)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "/login:password", string(body), "the server gets the body and the caller the response")
+	get(t, client, server.URL+"/accounts")
+	require.NoError(t, recorder.Save(path))
+	server.Close()
+
+	// THEN
+	recorded, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, recorded.Interactions, 2)
+	login := recorded.Interactions[0]
+	assert.Equal(t, Request{Method: "POST", URI: "/login?user=john", Header: http.Header{"Authorization": {Redacted}}, Body: "********"}, login.Request)
+	assert.Equal(t, 201, login.Response.StatusCode)
+	assert.Equal(t, Redacted, login.Response.Header.Get("Set-Cookie"))
+
+	assert.Equal(t, 202, recorded.Interactions[1].Response.StatusCode)
+}
+
+func testCassette() *Cassette {
+	return &Cassette{Interactions: []Interaction{
+		{Request{Method: "GET", URI: "/accounts/1"}, Response{StatusCode: 503, Body: "unavailable"}},
+		{Request{Method: "GET", URI: "/accounts/1"}, Response{StatusCode: 200, Body: "first"}},
+		{Request{Method: "POST", URI: "/accounts", Body: "second"}, Response{StatusCode: 201, Body: "created second"}},
+		{Request{Method: "POST", URI: "/accounts", Body: "third"}, Response{StatusCode: 201, Body: "created third"}},
+	}}
+}
+
+// In order, the interactions answer one after the other and a request out of turn fails
+func TestReplayInOrder(t *testing.T) {
+	// WHEN
+	replayer := NewReplayer(testCassette(), MatchInOrder)
+	client := &http.Client{Transport: replayer}
+
+	// THEN
+	status, body := get(t, client, "http://any-host/accounts/1")
+	assert.Equal(t, 503, status)
+	assert.Equal(t, "unavailable", body)
+	status, body = get(t, client, "http://other-host/accounts/1")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "first", body)
+
+	_, err := client.Get("http://any-host/accounts/1")
+	assert.True(t, errors.Is(err, ErrNoInteraction), err)
+	assert.Equal(t, 2, replayer.Unused())
+}
+
+// Matching picks the first unused interaction of the same method, uri and body
+func TestReplayByRequest(t *testing.T) {
+	// WHEN
+	replayer := NewReplayer(testCassette(), MatchRequest)
+	client := &http.Client{Transport: replayer}
+
+	// THEN
+	resp, err := client.Post("http://any-host/accounts", "text/plain", strings.NewReader("third"))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "created third", string(body))
+
+	status, _ := get(t, client, "http://any-host/accounts/1")
+	assert.Equal(t, 503, status)
+	status, _ = get(t, client, "http://any-host/accounts/1")
+	assert.Equal(t, 200, status)
+
+	_, err = client.Post("http://any-host/accounts", "text/plain", strings.NewReader("fourth"))
+	assert.True(t, errors.Is(err, ErrNoInteraction), err)
+	assert.Equal(t, 1, replayer.Unused())
+}
+
+func TestModeFromEnv(t *testing.T) {
+	// WHEN
+	t.Setenv(ModeEnv, "replay")
+
+	// THEN
+	mode, err := ModeFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, ModeReplay, mode)
+
+	t.Setenv(ModeEnv, "")
+	mode, err = ModeFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, ModeOff, mode)
+
+	t.Setenv(ModeEnv, "rewind")
+	_, err = ModeFromEnv()
+	assert.EqualError(t, err, `invalid CASSETTE_MODE "rewind", must be record or replay`)
+}
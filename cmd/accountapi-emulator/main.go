@@ -0,0 +1,127 @@
+// Command accountapi-emulator serves the account API from a local file, so the integration suite and
+// accountctl can run without the API image and its database.
+//
+// Usage:
+//
+//	accountapi-emulator [-addr :8080] [-data accounts.jsonl] [-seed seed.jsonl]
+//
+// Without -data the accounts live in memory only. The seed file is loaded when the store starts empty, so
+// restarting with the same flags keeps the accounts written since.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.form3-client.com/accounttest"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// seedableStore is a store that can be loaded in bulk
+type seedableStore interface {
+	accounttest.Store
+	Seed(r io.Reader) (int, error)
+	Close() error
+}
+
+type memorySeeder struct {
+	*accounttest.MemoryStore
+}
+
+func (s memorySeeder) Seed(r io.Reader) (int, error) {
+	return accounttest.Seed(s.MemoryStore, r)
+}
+
+func (s memorySeeder) Close() error {
+	return nil
+}
+
+func main() {
+	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	if err := run(os.Args[1:], logger); err != nil {
+		logger.Error().Err(err).Msg("emulator stopped")
+		os.Exit(1)
+	}
+}
+
+func run(args []string, logger zerolog.Logger) error {
+	flags := flag.NewFlagSet("accountapi-emulator", flag.ContinueOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	data := flags.String("data", "", "JSONL file the accounts are kept in, in memory only when empty")
+	seed := flags.String("seed", "", "JSONL file of accounts loaded when the store is empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openStore(*data)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if *seed != "" {
+		if err := seedStore(store, *seed, logger); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: *addr, Handler: accounttest.NewHandler(store)}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	logger.Info().Str("addr", *addr).Str("data", *data).Int("accounts", len(store.List())).Msg("serving the account API")
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not shut down: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info().Msg("shut down")
+	return nil
+}
+
+func openStore(path string) (seedableStore, error) {
+	if path == "" {
+		return memorySeeder{accounttest.NewMemoryStore()}, nil
+	}
+	return accounttest.OpenFileStore(path)
+}
+
+func seedStore(store seedableStore, path string, logger zerolog.Logger) error {
+	if len(store.List()) > 0 {
+		logger.Info().Str("seed", path).Msg("the store already holds accounts, not seeding")
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open the seed file: %w", err)
+	}
+	defer file.Close()
+	seeded, err := store.Seed(file)
+	if err != nil {
+		return fmt.Errorf("could not seed from %s: %w", path, err)
+	}
+	logger.Info().Str("seed", path).Int("accounts", seeded).Msg("seeded the store")
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"go.form3-client.com/accountplan"
+)
+
+type planOutput struct {
+	*accountplan.Plan
+}
+
+func (p planOutput) text() string {
+	return p.Plan.String()
+}
+
+type applyOutput struct {
+	Plan   *accountplan.Plan        `json:"plan"`
+	Result *accountplan.ApplyResult `json:"result"`
+}
+
+func (a applyOutput) text() string {
+	if a.Plan.IsEmpty() {
+		return a.Plan.String()
+	}
+	return a.Plan.String() + "\n\n" + a.Result.String()
+}
+
+func registerPlanFlags(fs *flag.FlagSet) (*string, *bool) {
+	manifest := fs.String("f", "", "manifest of the desired accounts, yaml or json")
+	prune := fs.Bool("prune", false, "delete the accounts of the manifest's organisations which are not in the manifest")
+	return manifest, prune
+}
+
+func computePlan(ctx context.Context, env *environment, manifestPath string, prune bool) (*accountplan.Plan, error) {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	manifest, err := accountplan.ReadManifest(file)
+	if err != nil {
+		return nil, err
+	}
+	return accountplan.Compute(ctx, env.client, manifest, accountplan.Options{Prune: prune})
+}
+
+func runPlan(ctx context.Context, env *environment, args []string) error {
+	fs := newFlagSet("plan", env)
+	manifestPath, prune := registerPlanFlags(fs)
+	if err := parseFlags(fs, args); err != nil || *manifestPath == "" {
+		return errUsage
+	}
+	plan, err := computePlan(ctx, env, *manifestPath, *prune)
+	if err != nil {
+		return err
+	}
+	return env.print(planOutput{plan})
+}
+
+func runApply(ctx context.Context, env *environment, args []string) error {
+	fs := newFlagSet("apply", env)
+	manifestPath, prune := registerPlanFlags(fs)
+	if err := parseFlags(fs, args); err != nil || *manifestPath == "" {
+		return errUsage
+	}
+	plan, err := computePlan(ctx, env, *manifestPath, *prune)
+	if err != nil {
+		return err
+	}
+	result, applyErr := accountplan.Apply(ctx, env.client, plan)
+	if err := env.print(applyOutput{Plan: plan, Result: result}); err != nil {
+		return err
+	}
+	return applyErr
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.form3-client.com/account"
+	"go.form3-client.com/accountio"
+)
+
+type attributeKind int
+
+const (
+	stringAttribute attributeKind = iota
+	listAttribute
+	boolAttribute
+)
+
+// attributeFlags are shared by create and update, the flag names are the json names with dashes
+var attributeFlags = []struct {
+	name string
+	kind attributeKind
+}{
+	{"country", stringAttribute},
+	{"bank_id", stringAttribute},
+	{"bank_id_code", stringAttribute},
+	{"bic", stringAttribute},
+	{"iban", stringAttribute},
+	{"account_number", stringAttribute},
+	{"base_currency", stringAttribute},
+	{"account_classification", stringAttribute},
+	{"secondary_identification", stringAttribute},
+	{"status", stringAttribute},
+	{"name", listAttribute},
+	{"alternative_names", listAttribute},
+	{"joint_account", boolAttribute},
+	{"account_matching_opt_out", boolAttribute},
+	{"switched", boolAttribute},
+}
+
+type attributeValues struct {
+	strings map[string]*string
+	bools   map[string]*bool
+}
+
+func registerAttributeFlags(fs *flag.FlagSet) *attributeValues {
+	values := &attributeValues{strings: make(map[string]*string), bools: make(map[string]*bool)}
+	for _, a := range attributeFlags {
+		flagName := strings.ReplaceAll(a.name, "_", "-")
+		switch a.kind {
+		case stringAttribute:
+			values.strings[flagName] = fs.String(flagName, "", a.name+" attribute")
+		case listAttribute:
+			values.strings[flagName] = fs.String(flagName, "", a.name+" attribute, entries separated by "+accountio.ListSeparator)
+		case boolAttribute:
+			values.bools[flagName] = fs.Bool(flagName, false, a.name+" attribute")
+		}
+	}
+	return values
+}
+
+// visited returns the json attributes of the flags given on the command line; an empty string stands for null
+func (v *attributeValues) visited(fs *flag.FlagSet) map[string]interface{} {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	attributes := make(map[string]interface{})
+	for _, a := range attributeFlags {
+		flagName := strings.ReplaceAll(a.name, "_", "-")
+		if !given[flagName] {
+			continue
+		}
+		switch a.kind {
+		case boolAttribute:
+			attributes[a.name] = *v.bools[flagName]
+		case listAttribute:
+			if value := *v.strings[flagName]; value != "" {
+				attributes[a.name] = strings.Split(value, accountio.ListSeparator)
+			} else {
+				attributes[a.name] = nil
+			}
+		default:
+			if value := *v.strings[flagName]; value != "" {
+				attributes[a.name] = value
+			} else {
+				attributes[a.name] = nil
+			}
+		}
+	}
+	return attributes
+}
+
+// convert moves a generic json document into one of the account types
+func convert(from interface{}, to interface{}) error {
+	encoded, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, to)
+}
+
+// splitID takes the account id in front of the flags
+func splitID(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+func newFlagSet(name string, env *environment) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return errUsage
+	}
+	return nil
+}
+
+type deleteResult struct {
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+}
+
+func (r deleteResult) text() string {
+	return "deleted " + r.ID
+}
+
+type importResult struct {
+	Records     int    `json:"records"`
+	Created     int    `json:"created"`
+	Invalid     int    `json:"invalid"`
+	Failed      int    `json:"failed"`
+	Skipped     int    `json:"skipped"`
+	ResultsFile string `json:"results_file"`
+}
+
+func (r importResult) text() string {
+	return fmt.Sprintf("records: %d, created: %d, invalid: %d, failed: %d, skipped: %d (results in %s)",
+		r.Records, r.Created, r.Invalid, r.Failed, r.Skipped, r.ResultsFile)
+}
+
+func runGet(ctx context.Context, env *environment, args []string) error {
+	id, rest := splitID(args)
+	if err := parseFlags(newFlagSet("get", env), rest); err != nil || id == "" {
+		return errUsage
+	}
+	acc, err := env.client.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	return env.print(acc)
+}
+
+func runCreate(ctx context.Context, env *environment, args []string) error {
+	fs := newFlagSet("create", env)
+	file := fs.String("file", "", "json file holding the account data, - for stdin")
+	id := fs.String("id", "", "account id, generated when not given")
+	organisationID := fs.String("organisation-id", "", "organisation id")
+	attributes := registerAttributeFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	data := &account.AccountData{}
+	if *file != "" {
+		if err := readAccountFile(*file, data); err != nil {
+			return err
+		}
+	} else {
+		data.ID, data.OrganisationID, data.Type = *id, *organisationID, "accounts"
+		if data.ID == "" {
+			data.ID = uuid.New().String()
+		}
+		data.Attributes = &account.AccountAttributes{}
+		if err := convert(attributes.visited(fs), data.Attributes); err != nil {
+			return err
+		}
+	}
+	if err := data.Validate(); err != nil {
+		return err
+	}
+
+	created, err := env.client.CreateAccount(ctx, data)
+	if err != nil {
+		return err
+	}
+	return env.print(created)
+}
+
+// readAccountFile accepts both the bare account and the {"data": ...} envelope of the API
+func readAccountFile(path string, data *account.AccountData) error {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	var envelope struct {
+		Data *account.AccountData `json:"data"`
+	}
+	if err := json.Unmarshal(content, &envelope); err == nil && envelope.Data != nil {
+		*data = *envelope.Data
+		return nil
+	}
+	if err := json.Unmarshal(content, data); err != nil {
+		return fmt.Errorf("could not read the account from %s: %w", path, err)
+	}
+	return nil
+}
+
+func runUpdate(ctx context.Context, env *environment, args []string) error {
+	id, rest := splitID(args)
+	fs := newFlagSet("update", env)
+	version := fs.Int64("version", -1, "current version of the account")
+	attributes := registerAttributeFlags(fs)
+	if err := parseFlags(fs, rest); err != nil || id == "" || *version < 0 {
+		return errUsage
+	}
+
+	var patch account.AccountPatch
+	if err := convert(attributes.visited(fs), &patch); err != nil {
+		return err
+	}
+	if patch.IsEmpty() {
+		fmt.Fprintln(env.stderr, "nothing to update, give at least one attribute flag; an empty value clears the attribute")
+		return errUsage
+	}
+	updated, err := env.client.PatchAccount(ctx, id, *version, &patch)
+	if err != nil {
+		return err
+	}
+	return env.print(updated)
+}
+
+func runDelete(ctx context.Context, env *environment, args []string) error {
+	id, rest := splitID(args)
+	fs := newFlagSet("delete", env)
+	version := fs.Int64("version", -1, "version to delete, the current one when not given")
+	ifExists := fs.Bool("if-exists", false, "do not fail when the account does not exist")
+	conflictRetries := fs.Int("conflict-retries", account.DefaultConflictRetries, "how many times to refetch the version on a version conflict when -version is not given, -1 for none")
+	if err := parseFlags(fs, rest); err != nil || id == "" {
+		return errUsage
+	}
+
+	opts := account.DeleteOptions{FetchVersion: *version < 0, ConflictRetries: *conflictRetries}
+	var err error
+	if *ifExists {
+		err = env.client.DeleteIfExists(ctx, id, opts)
+	} else {
+		err = env.client.DeleteAccountWithOptions(ctx, id, *version, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return env.print(deleteResult{ID: id, Deleted: true})
+}
+
+func runList(ctx context.Context, env *environment, args []string) error {
+	fs := newFlagSet("list", env)
+	page := fs.Int("page", 0, "page number, from 0")
+	size := fs.Int("size", account.DefaultPageSize, "page size")
+	all := fs.Bool("all", false, "walk every page")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if !*all {
+		listed, err := env.client.ListAccounts(ctx, *page, *size)
+		if err != nil {
+			return err
+		}
+		return env.print(listed.Accounts)
+	}
+	accounts := make([]*account.AccountData, 0)
+	err := env.client.WalkAccounts(ctx, *size, func(acc *account.AccountData) error {
+		accounts = append(accounts, acc)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return env.print(accounts)
+}
+
+func runImport(ctx context.Context, env *environment, args []string) error {
+	fs := newFlagSet("import", env)
+	file := fs.String("file", "", "jsonl or csv file to import")
+	format := fs.String("format", "", "jsonl or csv, guessed from the file extension when not given")
+	resultsPath := fs.String("results", "", "where to write the per line results, <file>.results.jsonl by default")
+	resume := fs.Bool("resume", false, "skip the lines already created or reported invalid according to the results file")
+	mapping := fs.String("map", "", "csv column mapping as field=column pairs separated by commas")
+	concurrency := fs.Int("concurrency", account.DefaultBulkConcurrency, "requests in flight")
+	stopOnError := fs.Bool("stop-on-error", false, "stop after the first failed create")
+	if err := parseFlags(fs, args); err != nil || *file == "" {
+		return errUsage
+	}
+
+	opts := accountio.ImportOptions{
+		Format: accountio.Format(*format),
+		Bulk:   account.BulkOptions{Concurrency: *concurrency, StopOnError: *stopOnError},
+	}
+	if opts.Format == "" {
+		opts.Format = formatFromPath(*file)
+	}
+	if *mapping != "" {
+		opts.CSVMapping = accountio.CSVMapping{}
+		for _, pair := range strings.Split(*mapping, ",") {
+			fieldName, column, ok := strings.Cut(pair, "=")
+			if !ok {
+				fmt.Fprintf(env.stderr, "invalid mapping %q, expected field=column\n", pair)
+				return errUsage
+			}
+			opts.CSVMapping[strings.TrimSpace(fieldName)] = strings.TrimSpace(column)
+		}
+	}
+	if *resultsPath == "" {
+		*resultsPath = *file + ".results.jsonl"
+	}
+	if *resume {
+		previous, err := os.Open(*resultsPath)
+		if err != nil {
+			return fmt.Errorf("cannot resume: %w", err)
+		}
+		opts.Handled, err = accountio.HandledLines(previous)
+		previous.Close()
+		if err != nil {
+			return fmt.Errorf("cannot resume: %w", err)
+		}
+	}
+
+	in, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if *resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	results, err := os.OpenFile(*resultsPath, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	summary, err := accountio.Import(ctx, env.client, in, results, opts)
+	env.print(importResult{summary.Records, summary.Created, summary.Invalid, summary.Failed, summary.Skipped, *resultsPath})
+	if err == nil && summary.Invalid+summary.Failed > 0 {
+		err = fmt.Errorf("%d records were not imported", summary.Invalid+summary.Failed)
+	}
+	return err
+}
+
+func runExport(ctx context.Context, env *environment, args []string) error {
+	fs := newFlagSet("export", env)
+	format := fs.String("format", "", "jsonl or csv, guessed from the output extension and jsonl otherwise")
+	fields := fs.String("fields", "", "comma separated fields to export, all of "+strings.Join(accountio.FieldNames(), ",")+" by default")
+	output := fs.String("output", "", "file to write to, stdout by default")
+	pageSize := fs.Int("page-size", account.DefaultPageSize, "accounts fetched per request")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	opts := accountio.ExportOptions{Format: accountio.Format(*format), PageSize: *pageSize}
+	if opts.Format == "" {
+		opts.Format = formatFromPath(*output)
+	}
+	if *fields != "" {
+		opts.Fields = strings.Split(*fields, ",")
+	}
+	out := env.stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	written, err := accountio.Export(ctx, env.client, out, opts)
+	fmt.Fprintf(env.stderr, "exported %d accounts\n", written)
+	return err
+}
+
+func formatFromPath(path string) accountio.Format {
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		return accountio.FormatCSV
+	}
+	return accountio.FormatJSONL
+}
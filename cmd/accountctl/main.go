@@ -0,0 +1,138 @@
+// Command accountctl inspects and fixes accounts through the account API.
+//
+// The API host is read from the HOST_ADDRESS environment variable and defaults to http://localhost:8080.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"go.form3-client.com/account"
+)
+
+const (
+	hostAddressName = "HOST_ADDRESS"
+	defaultHost     = "http://localhost:8080"
+	// recordTimeout is the default deadline of the commands working on a record or a page; the streaming and
+	// bulk ones run for as long as their input, so they have none unless -timeout is given
+	recordTimeout = 30 * time.Second
+)
+
+// errUsage is returned by the commands for bad arguments, its details are already printed
+var errUsage = errors.New("usage error")
+
+type command struct {
+	usage   string
+	run     func(ctx context.Context, env *environment, args []string) error
+	timeout time.Duration // when -timeout is not given; 0 for no deadline
+}
+
+var commands = map[string]command{
+	"get":    {"get <account id>", runGet, recordTimeout},
+	"create": {"create [-file account.json | attribute flags]", runCreate, recordTimeout},
+	"update": {"update <account id> -version <version> <attribute flags>", runUpdate, recordTimeout},
+	"delete": {"delete <account id> [-version <version>] [-if-exists]", runDelete, recordTimeout},
+	"list":   {"list [-page <number>] [-size <size>] [-all]", runList, recordTimeout},
+	"import": {"import -file <path> [-format jsonl|csv] [-results <path>] [-resume]", runImport, 0},
+	"export": {"export [-format jsonl|csv] [-fields id,country,...] [-output <path>]", runExport, 0},
+	"plan":   {"plan -f <manifest.yaml> [-prune]", runPlan, 0},
+	"apply":  {"apply -f <manifest.yaml> [-prune]", runApply, 0},
+}
+
+// environment is what the commands share: the client, where to write and how
+type environment struct {
+	client   *account.AccountClient
+	renderer renderer
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+func (env *environment) print(v interface{}) error {
+	return env.renderer.render(env.stdout, v)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("accountctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	timeout := global.Duration("timeout", 0, fmt.Sprintf("overall timeout of the command, 0 for none (default %v for get, create, update, delete and list, none for the others)", recordTimeout))
+	output := global.String("o", outputTable, "output format: table, json, yaml or template")
+	templateText := global.String("template", "", "go text/template applied to every account, implies -o template")
+	global.Usage = func() { printUsage(global) }
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		printUsage(global)
+		return 2
+	}
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", global.Arg(0))
+		printUsage(global)
+		return 2
+	}
+
+	renderer, err := newRenderer(*output, *templateText)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	hostAddress := defaultHost
+	if fromEnv, ok := os.LookupEnv(hostAddressName); ok {
+		hostAddress = fromEnv
+	}
+	client, err := account.NewAccountClient(hostAddress, &http.Client{Timeout: account.ClientTimeout})
+	if err != nil {
+		renderer.renderError(stderr, fmt.Errorf("invalid %s: %w", hostAddressName, err))
+		return 2
+	}
+
+	deadline := cmd.timeout
+	global.Visit(func(f *flag.Flag) {
+		if f.Name == "timeout" {
+			deadline = *timeout
+		}
+	})
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+	}
+	defer cancel()
+	env := &environment{client: client, renderer: renderer, stdout: stdout, stderr: stderr}
+	err = cmd.run(ctx, env, global.Args()[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "usage: accountctl %s\n", cmd.usage)
+		return 2
+	case err != nil:
+		renderer.renderError(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(global *flag.FlagSet) {
+	out := global.Output()
+	fmt.Fprintf(out, "usage: accountctl [-timeout <duration>] [-o table|json|yaml] [-template <text>] <command> [flags]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(out, "\nthe API host is read from %s (default %s)\n", hostAddressName, defaultHost)
+}
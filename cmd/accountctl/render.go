@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"text/template"
+
+	"go.form3-client.com/account"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable    = "table"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputTemplate = "template"
+)
+
+// renderer prints the results of the commands and their errors in one of the output formats
+type renderer interface {
+	render(w io.Writer, v interface{}) error
+	renderError(w io.Writer, err error) error
+}
+
+// texter is implemented by the results which are not accounts, for the table output
+type texter interface {
+	text() string
+}
+
+func newRenderer(output, templateText string) (renderer, error) {
+	if templateText != "" {
+		output = outputTemplate
+	}
+	switch output {
+	case outputTable:
+		return tableRenderer{}, nil
+	case outputJSON:
+		return jsonRenderer{}, nil
+	case outputYAML:
+		return yamlRenderer{}, nil
+	case outputTemplate:
+		if templateText == "" {
+			return nil, errors.New("the template output needs -template")
+		}
+		tmpl, err := template.New("output").Parse(templateText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return templateRenderer{tmpl}, nil
+	default:
+		return nil, fmt.Errorf("unknown output %q, expected one of table, json, yaml or template", output)
+	}
+}
+
+// document wraps accounts in the JSON:API envelope the server uses, other results are left as they are
+func document(v interface{}) interface{} {
+	switch v.(type) {
+	case *account.AccountData, []*account.AccountData:
+		return map[string]interface{}{"data": v}
+	}
+	return v
+}
+
+type errorObject struct {
+	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+	Detail string `json:"detail" yaml:"detail"`
+}
+
+// errorDocument follows the JSON:API error object
+func errorDocument(err error) map[string][]errorObject {
+	object := errorObject{Detail: err.Error()}
+	var responseErr *account.ResponseError
+	if errors.As(err, &responseErr) {
+		object.Status, object.Detail = strconv.Itoa(responseErr.StatusCode), responseErr.Message
+	}
+	return map[string][]errorObject{"errors": {object}}
+}
+
+type jsonRenderer struct{}
+
+func (jsonRenderer) render(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document(v))
+}
+
+func (r jsonRenderer) renderError(w io.Writer, err error) error {
+	return r.render(w, errorDocument(err))
+}
+
+type yamlRenderer struct{}
+
+// render goes through json first so that the yaml keys are the json names of the API
+func (yamlRenderer) render(w io.Writer, v interface{}) error {
+	encoded, err := json.Marshal(document(v))
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return err
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(generic); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func (r yamlRenderer) renderError(w io.Writer, err error) error {
+	return r.render(w, errorDocument(err))
+}
+
+type templateRenderer struct {
+	tmpl *template.Template
+}
+
+// render runs the template once per account so that '{{.ID}}' works for get and list alike
+func (r templateRenderer) render(w io.Writer, v interface{}) error {
+	if accounts, ok := v.([]*account.AccountData); ok {
+		for _, acc := range accounts {
+			if err := r.render(w, acc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := r.tmpl.Execute(w, v); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func (templateRenderer) renderError(w io.Writer, err error) error {
+	return tableRenderer{}.renderError(w, err)
+}
+
+type tableRenderer struct{}
+
+func (tableRenderer) render(w io.Writer, v interface{}) error {
+	var accounts []*account.AccountData
+	switch value := v.(type) {
+	case *account.AccountData:
+		accounts = []*account.AccountData{value}
+	case []*account.AccountData:
+		accounts = value
+	case texter:
+		_, err := fmt.Fprintln(w, value.text())
+		return err
+	default:
+		return fmt.Errorf("cannot render %T as a table", v)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tCOUNTRY\tBANK ID\tACCOUNT NUMBER\tSTATUS\tVERSION")
+	for _, acc := range accounts {
+		attributes := acc.Attributes
+		if attributes == nil {
+			attributes = &account.AccountAttributes{}
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\n",
+			acc.ID, attributes.Country, attributes.BankID, attributes.AccountNumber, attributes.Status, acc.Version)
+	}
+	return table.Flush()
+}
+
+func (tableRenderer) renderError(w io.Writer, err error) error {
+	_, writeErr := fmt.Fprintf(w, "error: %s\n", err)
+	return writeErr
+}
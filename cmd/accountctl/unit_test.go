@@ -0,0 +1,245 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	accountID = "ad27e265-9605-4b4b-a0e5-3003ea9cc4dc"
+	orgID     = "eb0bd6f5-c3f5-44b2-b677-acd23cdde73c"
+)
+
+type recorded struct {
+	method string
+	uri    string
+	body   map[string]interface{}
+}
+
+// newServer answers every request with the same account and records what it was sent
+func newServer(t *testing.T) (*[]recorded, func()) {
+	var requests []recorded
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := recorded{method: r.Method, uri: r.URL.RequestURI()}
+		if raw, _ := io.ReadAll(r.Body); len(raw) > 0 {
+			json.Unmarshal(raw, &rec.body)
+		}
+		requests = append(requests, rec)
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(204)
+		case r.Method == http.MethodPost:
+			w.WriteHeader(201)
+			json.NewEncoder(w).Encode(rec.body)
+		case r.URL.Query().Get("page[size]") != "":
+			w.WriteHeader(200)
+			w.Write([]byte(`{"data": [{"id": "` + accountID + `", "attributes": {"country": "GB"}}, {"id": "` + orgID + `"}]}`))
+		default:
+			w.WriteHeader(200)
+			w.Write([]byte(`{"data": {"id": "` + accountID + `", "organisation_id": "` + orgID + `", "type": "accounts", "version": 4, "attributes": {"country": "GB"}}}`))
+		}
+	}))
+	t.Setenv(hostAddressName, server.URL)
+	return &requests, server.Close
+}
+
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// The single record commands have a deadline by default, the bulk ones none, and -timeout overrides both
+func TestCommandTimeouts(t *testing.T) {
+	// WHEN
+	var deadline time.Duration
+	probe := func(ctx context.Context, env *environment, args []string) error {
+		deadline = 0
+		if at, ok := ctx.Deadline(); ok {
+			deadline = time.Until(at).Round(time.Second)
+		}
+		return nil
+	}
+	commands["record-probe"] = command{"record-probe", probe, recordTimeout}
+	commands["bulk-probe"] = command{"bulk-probe", probe, 0}
+	defer delete(commands, "record-probe")
+	defer delete(commands, "bulk-probe")
+
+	// THEN
+	cases := []struct {
+		args     []string
+		expected time.Duration
+	}{
+		{[]string{"record-probe"}, recordTimeout},
+		{[]string{"bulk-probe"}, 0},
+		{[]string{"-timeout", "2h", "bulk-probe"}, 2 * time.Hour},
+		{[]string{"-timeout", "0", "record-probe"}, 0},
+	}
+	for _, tc := range cases {
+		code, _, _ := runCommand(tc.args...)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, tc.expected, deadline, strings.Join(tc.args, " "))
+	}
+	for _, name := range []string{"import", "export", "plan", "apply"} {
+		assert.Zero(t, commands[name].timeout, name)
+	}
+}
+
+// get prints the fetched account
+func TestGet(t *testing.T) {
+	// WHEN
+	requests, closeServer := newServer(t)
+	defer closeServer()
+
+	// THEN
+	code, stdout, _ := runCommand("get", accountID)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "ID                                    COUNTRY  BANK ID  ACCOUNT NUMBER  STATUS  VERSION\n"+
+		accountID+"  GB                                        4\n", stdout)
+	assert.Equal(t, "/v1/organisation/accounts/"+accountID, (*requests)[0].uri)
+
+	code, _, stderr := runCommand("get")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "usage: accountctl get <account id>")
+}
+
+// create builds the account from the attribute flags and validates it first
+func TestCreateFromFlags(t *testing.T) {
+	// WHEN
+	requests, closeServer := newServer(t)
+	defer closeServer()
+
+	// THEN
+	code, _, stderr := runCommand("create", "-id", accountID, "-organisation-id", orgID, "-country", "GB", "-name", "John;Doe", "-switched")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, map[string]interface{}{
+		"id": accountID, "organisation_id": orgID, "type": "accounts",
+		"attributes": map[string]interface{}{"country": "GB", "name": []interface{}{"John", "Doe"}, "switched": true},
+	}, (*requests)[0].body["data"])
+
+	code, _, stderr = runCommand("create", "-organisation-id", orgID, "-country", "gb")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "error: country in body should match '^[A-Z]{2}$'\n", stderr)
+	assert.Len(t, *requests, 1)
+}
+
+// update only sends the flags given and clears the empty ones
+func TestUpdateSendsAPatch(t *testing.T) {
+	// WHEN
+	requests, closeServer := newServer(t)
+	defer closeServer()
+
+	// THEN
+	code, _, stderr := runCommand("update", accountID, "-version", "4", "-switched=false", "-bic", "")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "PATCH", (*requests)[0].method)
+	assert.Equal(t, map[string]interface{}{"switched": false, "bic": nil}, (*requests)[0].body["data"].(map[string]interface{})["attributes"])
+
+	code, _, _ = runCommand("update", accountID, "-version", "4")
+	assert.Equal(t, 2, code)
+}
+
+// delete looks the version up when it is not given
+func TestDeleteWithoutVersion(t *testing.T) {
+	// WHEN
+	requests, closeServer := newServer(t)
+	defer closeServer()
+
+	// THEN
+	code, stdout, _ := runCommand("delete", accountID)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "deleted "+accountID+"\n", stdout)
+	assert.Equal(t, "GET", (*requests)[0].method)
+	assert.Equal(t, "/v1/organisation/accounts/"+accountID+"?version=4", (*requests)[1].uri)
+}
+
+// unknown commands and bad host addresses are usage errors
+func TestUsageErrors(t *testing.T) {
+	code, _, stderr := runCommand("frobnicate")
+	assert.Equal(t, 2, code)
+	assert.True(t, strings.HasPrefix(stderr, `unknown command "frobnicate"`))
+
+	t.Setenv(hostAddressName, "localhost:8080")
+	code, _, stderr = runCommand("get", accountID)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "invalid HOST_ADDRESS")
+}
+
+// every output format renders the account and the errors alike
+func TestOutputFormats(t *testing.T) {
+	// WHEN
+	_, closeServer := newServer(t)
+	defer closeServer()
+
+	// THEN
+	code, stdout, _ := runCommand("-o", "json", "get", accountID)
+	assert.Equal(t, 0, code)
+	var document map[string]map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &document))
+	assert.Equal(t, accountID, document["data"]["id"])
+
+	code, stdout, _ = runCommand("-o", "yaml", "get", accountID)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "data:\n  attributes:\n    country: GB\n  id: "+accountID+"\n")
+
+	code, stdout, _ = runCommand("-template", "{{.ID}}", "list")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, accountID+"\n"+orgID+"\n", stdout)
+
+	code, stdout, _ = runCommand("-template", "{{.ID}} v{{.Version}}", "get", accountID)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, accountID+" v4\n", stdout)
+
+	code, _, _ = runCommand("-o", "xml", "get", accountID)
+	assert.Equal(t, 2, code)
+}
+
+// errors follow the output format so that scripts can parse them
+func TestErrorOutput(t *testing.T) {
+	// WHEN
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte(`{"error_message": "record ` + accountID + ` does not exist"}`))
+	}))
+	defer server.Close()
+	t.Setenv(hostAddressName, server.URL)
+
+	// THEN
+	code, _, stderr := runCommand("-o", "json", "get", accountID)
+	assert.Equal(t, 1, code)
+	assert.JSONEq(t, `{"errors": [{"status": "404", "detail": "record `+accountID+` does not exist"}]}`, stderr)
+
+	_, _, stderr = runCommand("-o", "yaml", "get", accountID)
+	assert.Equal(t, "errors:\n  - detail: record "+accountID+" does not exist\n    status: \"404\"\n", stderr)
+
+	_, _, stderr = runCommand("get", accountID)
+	assert.Equal(t, "error: response status code 404 with error message: record "+accountID+" does not exist\n", stderr)
+}
+
+// plan prints the field level diff against the live account
+func TestPlan(t *testing.T) {
+	// WHEN
+	_, closeServer := newServer(t)
+	defer closeServer()
+	manifest := filepath.Join(t.TempDir(), "accounts.yaml")
+	os.WriteFile(manifest, []byte("accounts:\n  - id: "+accountID+"\n    organisation_id: "+orgID+"\n    attributes:\n      country: FR\n"), 0o600)
+
+	// THEN
+	code, stdout, stderr := runCommand("plan", "-f", manifest)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "~ update "+accountID+" (version 4)\n    country: \"GB\" -> \"FR\"\nplan: 0 to create, 1 to update, 0 to delete\n", stdout)
+}
@@ -0,0 +1,100 @@
+// Command accountload measures the capacity of the account API: it runs a mix of creates, gets and deletes
+// at a target rate or concurrency and prints the latency percentiles, the errors and the retries.
+//
+// Usage:
+//
+//	accountload [-rate 200] [-concurrency 50] [-duration 1m] [-requests 0] [-mix create=2,get=5,delete=1] [-o text|json]
+//
+// The API host is read from the HOST_ADDRESS environment variable and defaults to http://localhost:8080. The
+// command exits with 1 when any operation failed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"go.form3-client.com/account"
+	"go.form3-client.com/accountload"
+)
+
+const (
+	hostAddressName = "HOST_ADDRESS"
+	defaultHost     = "http://localhost:8080"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("accountload", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	rate := flags.Float64("rate", 0, "operations started per second, 0 runs the workers as fast as the API answers")
+	concurrency := flags.Int("concurrency", accountload.DefaultConcurrency, "number of workers")
+	duration := flags.Duration("duration", 30*time.Second, "how long to run, 0 to stop after -requests only")
+	requests := flags.Int("requests", 0, "number of operations to run, 0 for no limit")
+	mixText := flags.String("mix", "create=1,get=1,delete=1", "weights of the operations")
+	timeout := flags.Duration("timeout", account.ClientTimeout, "timeout of every operation")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed of the operation mix")
+	output := flags.String("o", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	mix, err := accountload.ParseMix(*mixText)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "unknown output format %q\n", *output)
+		return 2
+	}
+
+	hostAddress := defaultHost
+	if fromEnv, ok := os.LookupEnv(hostAddressName); ok {
+		hostAddress = fromEnv
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *concurrency // the default of 2 would make most workers dial every request
+	httpClient := &http.Client{Timeout: *timeout, Transport: accountload.CountAttempts(transport)}
+	client, err := account.NewAccountClient(hostAddress, httpClient)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid %s: %s\n", hostAddressName, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt) // ^C stops the run and still reports
+	defer stop()
+	report, err := accountload.Run(ctx, client, accountload.Config{
+		Rate:        *rate,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Requests:    *requests,
+		Mix:         mix,
+		Timeout:     *timeout,
+		Seed:        *seed,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		fmt.Fprint(stdout, report)
+	}
+	if report.Failed() > 0 {
+		return 1
+	}
+	return 0
+}
@@ -5,9 +5,11 @@ go 1.18
 require github.com/google/uuid v1.3.0
 
 require (
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
 	github.com/bluele/factory-go v0.0.1
 	github.com/rs/zerolog v1.27.0
 	github.com/stretchr/testify v1.7.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -16,5 +18,4 @@ require (
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20220622161953-175b2fd9d664 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
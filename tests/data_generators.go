@@ -4,6 +4,7 @@
 package tests
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 
@@ -18,11 +19,48 @@ var (
 	bankCodeRange = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
 
+// generator draws the ids and the random attributes of a test's accounts from a source of its own, so that
+// seeding it changes nothing for the other tests; it is not safe for concurrent use
+type generator struct {
+	r *rand.Rand
+}
+
+type generatorKey struct{}
+
+func newGenerator(seed int64) *generator {
+	return &generator{r: rand.New(rand.NewSource(seed))}
+}
+
+func (g *generator) uuid() string {
+	return uuid.Must(uuid.NewRandomFromReader(g.r)).String()
+}
+
+// account creates an account with AccountDataFactory, opt overriding the generated fields
+func (g *generator) account(opt map[string]interface{}) *account.AccountData {
+	ctx := context.WithValue(context.Background(), generatorKey{}, g)
+	return AccountDataFactory.MustCreateWithContextAndOption(ctx, opt).(*account.AccountData)
+}
+
+// intn draws from the generator the account is created with, or from the global source without one
+func intn(args factory.Args, n int) int {
+	if g, ok := args.Context().Value(generatorKey{}).(*generator); ok {
+		return g.r.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+func newUUID(args factory.Args) string {
+	if g, ok := args.Context().Value(generatorKey{}).(*generator); ok {
+		return g.uuid()
+	}
+	return uuid.New().String()
+}
+
 var AccountDataFactory = factory.NewFactory(
 	&account.AccountData{Type: "accounts"}).Attr("ID", func(args factory.Args) (interface{}, error) {
-	return uuid.New().String(), nil
+	return newUUID(args), nil
 }).Attr("OrganisationID", func(args factory.Args) (interface{}, error) {
-	return uuid.New().String(), nil
+	return newUUID(args), nil
 }).Attr("Version", func(args factory.Args) (interface{}, error) {
 	return int64(0), nil
 }).Attr("Type", func(args factory.Args) (interface{}, error) {
@@ -30,20 +68,20 @@ var AccountDataFactory = factory.NewFactory(
 }).SubFactory("Attributes", AccountAttributesFactory)
 
 var AccountAttributesFactory = factory.NewFactory(&account.AccountAttributes{}).Attr("Name", func(args factory.Args) (interface{}, error) {
-	len := rand.Int()%account.MaxNames + 1
+	len := intn(args, account.MaxNames) + 1
 	names := make([]string, 0, len)
 	for i := 0; i < len; i++ {
-		names = append(names, fmt.Sprintf("%s %d", namePrefixes[i], rand.Int()%1000))
+		names = append(names, fmt.Sprintf("%s %d", namePrefixes[i], intn(args, 1000)))
 	}
 	return names, nil
 }).Attr("Country", func(args factory.Args) (interface{}, error) {
-	index := rand.Int() % len(countries)
+	index := intn(args, len(countries))
 	return countries[index], nil
 }).Attr("BankIDCode", func(args factory.Args) (interface{}, error) {
-	length := rand.Int() % (account.MaxBankCodeLength + 1)
+	length := intn(args, account.MaxBankCodeLength+1)
 	bankCode := make([]byte, length)
 	for ind := range bankCode {
-		bankCode[ind] = bankCodeRange[rand.Intn(len(bankCodeRange))]
+		bankCode[ind] = bankCodeRange[intn(args, len(bankCodeRange))]
 	}
 	return string(bankCode), nil
 })
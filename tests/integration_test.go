@@ -10,16 +10,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 
-	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"go.form3-client.com/account"
 )
 
 func TestCreateAccount(t *testing.T) {
 	hc := http.Client{Timeout: account.ClientTimeout}
-	client := account.NewAccountClient(fetchAPIHostName(), &hc)
+	client := newAccountClient(t, &hc)
+	gen := testData(t)
 
 	type testCase struct {
 		name             string
@@ -28,8 +29,8 @@ func TestCreateAccount(t *testing.T) {
 	}
 
 	minimalAccountData := &account.AccountData{
-		ID:             uuid.New().String(),
-		OrganisationID: uuid.New().String(),
+		ID:             gen.uuid(),
+		OrganisationID: gen.uuid(),
 		Type:           "accounts", // this seems to be required for the Create operation although the APIdocs say its optional...
 		Attributes: &account.AccountAttributes{
 			Country: "RO",
@@ -44,50 +45,43 @@ func TestCreateAccount(t *testing.T) {
 		},
 		{
 			name:             "account type is required",
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(map[string]interface{}{"Type": "invalid type"}).(*account.AccountData),
+			givenAccountdata: gen.account(map[string]interface{}{"Type": "invalid type"}),
 			expectedErrror:   errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\ntype in body should be one of [accounts]"),
 		},
 		{
-			name: "country attrribute is required",
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(
-				map[string]interface{}{"Attributes.Country": ""}).(*account.AccountData),
-			expectedErrror: errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\ncountry in body is required"),
+			name:             "country attrribute is required",
+			givenAccountdata: gen.account(map[string]interface{}{"Attributes.Country": ""}),
+			expectedErrror:   errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\ncountry in body is required"),
 		},
 		{
-			name: "invalid account id",
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(
-				map[string]interface{}{"ID": "invalid-id"}).(*account.AccountData),
-			expectedErrror: errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nid in body must be of type uuid: \"invalid-id\""),
+			name:             "invalid account id",
+			givenAccountdata: gen.account(map[string]interface{}{"ID": "invalid-id"}),
+			expectedErrror:   errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nid in body must be of type uuid: \"invalid-id\""),
 		},
 		{
-			name: "country is validated",
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(
-				map[string]interface{}{"Attributes.Country": "invalid"}).(*account.AccountData),
-			expectedErrror: errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\ncountry in body should match '^[A-Z]{2}$'"),
+			name:             "country is validated",
+			givenAccountdata: gen.account(map[string]interface{}{"Attributes.Country": "invalid"}),
+			expectedErrror:   errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\ncountry in body should match '^[A-Z]{2}$'"),
 		},
 		{
-			name: "bank id code is validated",
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(
-				map[string]interface{}{"Attributes.BankIDCode": "WRONGID121212"}).(*account.AccountData),
-			expectedErrror: errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\nbank_id_code in body should match '^[A-Z]{0,16}$'"),
+			name:             "bank id code is validated",
+			givenAccountdata: gen.account(map[string]interface{}{"Attributes.BankIDCode": "WRONGID121212"}),
+			expectedErrror:   errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\nbank_id_code in body should match '^[A-Z]{0,16}$'"),
 		},
 		{
-			name: "bic code is validated",
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(
-				map[string]interface{}{"Attributes.Bic": "WRONGBIC123213"}).(*account.AccountData),
-			expectedErrror: errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\nbic in body should match '^([A-Z]{6}[A-Z0-9]{2}|[A-Z]{6}[A-Z0-9]{5})$'"),
+			name:             "bic code is validated",
+			givenAccountdata: gen.account(map[string]interface{}{"Attributes.Bic": "WRONGBIC123213"}),
+			expectedErrror:   errors.New("response status code 400 with error message: validation failure list:\nvalidation failure list:\nvalidation failure list:\nbic in body should match '^([A-Z]{6}[A-Z0-9]{2}|[A-Z]{6}[A-Z0-9]{5})$'"),
 		},
 		{
-			name: "bank details are not country conditional",
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(
-				map[string]interface{}{"Attributes.Country": "GB", "Attributes.Bic": "RIGHTBIC", "Attributes.BankID": "SOMEBANKID"}).(*account.AccountData),
-			expectedErrror: nil,
+			name:             "bank details are not country conditional",
+			givenAccountdata: gen.account(map[string]interface{}{"Attributes.Country": "GB", "Attributes.Bic": "RIGHTBIC", "Attributes.BankID": "SOMEBANKID"}),
+			expectedErrror:   nil,
 		},
 		{
-			name: "iban is vaidated but not country conditonal", // for Canada APIdocs say it must be empty
-			givenAccountdata: AccountDataFactory.MustCreateWithOption(
-				map[string]interface{}{"Attributes.Country": "CA", "Attributes.Iban": "SB01AWESOMEIBAN"}).(*account.AccountData),
-			expectedErrror: nil,
+			name:             "iban is vaidated but not country conditonal", // for Canada APIdocs say it must be empty
+			givenAccountdata: gen.account(map[string]interface{}{"Attributes.Country": "CA", "Attributes.Iban": "SB01AWESOMEIBAN"}),
+			expectedErrror:   nil,
 		},
 	}
 	ctx := context.Background()
@@ -97,7 +91,11 @@ func TestCreateAccount(t *testing.T) {
 			resp, err := client.CreateAccount(ctx, tc.givenAccountdata)
 
 			// THEN
-			assert.Equal(t, tc.expectedErrror, err, "submitted data: %s", tc.givenAccountdata)
+			if tc.expectedErrror == nil {
+				assert.NoError(t, err, "submitted data: %s", tc.givenAccountdata)
+			} else {
+				assert.EqualError(t, err, tc.expectedErrror.Error(), "submitted data: %s", tc.givenAccountdata)
+			}
 			if err == nil {
 				assert.Equal(t, tc.givenAccountdata, resp)
 			}
@@ -107,30 +105,32 @@ func TestCreateAccount(t *testing.T) {
 
 func TestCreateAccountWithExistingIDFails(t *testing.T) {
 	hc := http.Client{Timeout: account.ClientTimeout}
-	client := account.NewAccountClient(fetchAPIHostName(), &hc)
-	fixedID := uuid.New().String()
+	client := newAccountClient(t, &hc)
+	gen := testData(t)
+	fixedID := gen.uuid()
 	ctx := context.Background()
 
 	// WHEN
-	accountData := AccountDataFactory.MustCreateWithOption(map[string]interface{}{"ID": fixedID}).(*account.AccountData)
+	accountData := gen.account(map[string]interface{}{"ID": fixedID})
 	var err error
 	_, err = client.CreateAccount(ctx, accountData)
 	assert.Nil(t, err)
 
 	// THEN
-	anotherAccountData := AccountDataFactory.MustCreateWithOption(map[string]interface{}{"ID": fixedID}).(*account.AccountData)
+	anotherAccountData := gen.account(map[string]interface{}{"ID": fixedID})
 	_, err = client.CreateAccount(ctx, anotherAccountData)
 	assert.Error(t, err)
 }
 
 func TestCanFetch(t *testing.T) {
 	hc := http.Client{Timeout: account.ClientTimeout}
-	ac := account.NewAccountClient(fetchAPIHostName(), &hc)
+	ac := newAccountClient(t, &hc)
+	gen := testData(t)
 	ctx := context.Background()
 
 	t.Run("can fetch account data", func(t *testing.T) {
 		// WHEN
-		data := AccountDataFactory.MustCreate().(*account.AccountData)
+		data := gen.account(nil)
 		ac.CreateAccount(ctx, data)
 
 		// THEN
@@ -145,14 +145,13 @@ func TestCanFetch(t *testing.T) {
 
 		// THEN
 		assert.Nil(t, fetchedData)
-		assert.NotNil(t, err)
-		assert.Equal(t, "response status code 400 with error message: id is not a valid uuid", err.Error())
+		assert.ErrorIs(t, err, account.ErrInvalidAccountID) // rejected before reaching the server
 
 	})
 
 	t.Run("fetch non existing account", func(t *testing.T) {
 		// WHEN
-		notInsertedAccount := AccountDataFactory.MustCreate().(*account.AccountData) // only need the generated ID
+		notInsertedAccount := gen.account(nil) // only need the generated ID
 		fetchedData, err := ac.GetById(ctx, notInsertedAccount.ID)
 
 		// THEN
@@ -165,12 +164,13 @@ func TestCanFetch(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	hc := http.Client{Timeout: account.ClientTimeout}
-	ac := account.NewAccountClient(fetchAPIHostName(), &hc)
+	ac := newAccountClient(t, &hc)
+	gen := testData(t)
 	ctx := context.Background()
 
 	t.Run("can delete successfully", func(t *testing.T) {
 		// WHEN
-		data := AccountDataFactory.MustCreate().(*account.AccountData)
+		data := gen.account(nil)
 		ac.CreateAccount(ctx, data)
 
 		// THEN
@@ -180,31 +180,78 @@ func TestDelete(t *testing.T) {
 
 	t.Run("can delete successfully", func(t *testing.T) {
 		// WHEN
-		data := AccountDataFactory.MustCreate().(*account.AccountData)
+		data := gen.account(nil)
 		ac.CreateAccount(ctx, data)
 
 		// THEN
 		err := ac.DeleteAccount(ctx, data.ID, data.Version)
 		assert.NoError(t, err)
 	})
+
+	t.Run("can delete without knowing the version", func(t *testing.T) {
+		// WHEN
+		data := gen.account(nil)
+		ac.CreateAccount(ctx, data)
+
+		// THEN
+		err := ac.DeleteAccountWithOptions(ctx, data.ID, 42, account.DeleteOptions{FetchVersion: true})
+		assert.NoError(t, err)
+		_, err = ac.GetById(ctx, data.ID)
+		assert.ErrorIs(t, err, account.ErrNotFound)
+	})
+
+	t.Run("delete if exists ignores missing accounts", func(t *testing.T) {
+		// WHEN
+		notInsertedAccount := gen.account(nil)
+
+		// THEN
+		err := ac.DeleteIfExists(ctx, notInsertedAccount.ID, account.DeleteOptions{})
+		assert.NoError(t, err)
+	})
 }
 
 func TestPatch(t *testing.T) {
 	hc := http.Client{Timeout: account.ClientTimeout}
-	ac := account.NewAccountClient(fetchAPIHostName(), &hc)
+	ac := newAccountClient(t, &hc)
+	gen := testData(t)
 	ctx := context.Background()
 
-	t.Run("cannot patch data; likely not implemented on the server side", func(t *testing.T) {
+	t.Run("can patch account data", func(t *testing.T) {
 		// WHEN
-		data := AccountDataFactory.MustCreate().(*account.AccountData)
+		data := gen.account(nil)
 		result, err := ac.CreateAccount(ctx, data)
 		assert.NoError(t, err)
-		result.Attributes.Country = "ZB"
-		result.Version++
-		_, err = ac.UpdateAccount(ctx, data)
+		result.Attributes.Country = "FR"
+		updated, err := ac.UpdateAccount(ctx, result)
+		if err != nil && strings.HasPrefix(err.Error(), "response status code 405") {
+			t.Skip("this accountapi image does not implement PATCH")
+		}
 
 		// THEN
-		assert.Equal(t, err.Error(), "response status code 404 with error message: ")
+		assert.NoError(t, err)
+		assert.Equal(t, "FR", updated.Attributes.Country)
+	})
+}
+
+func TestList(t *testing.T) {
+	hc := http.Client{Timeout: account.ClientTimeout}
+	ac := newAccountClient(t, &hc)
+	gen := testData(t)
+	ctx := context.Background()
+
+	t.Run("created accounts show up in the list", func(t *testing.T) {
+		// WHEN
+		data := gen.account(nil)
+		_, err := ac.CreateAccount(ctx, data)
+		assert.NoError(t, err)
 
+		// THEN
+		found := false
+		err = ac.WalkAccounts(ctx, 50, func(acc *account.AccountData) error {
+			found = found || acc.ID == data.ID
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.True(t, found)
 	})
 }
@@ -15,6 +15,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"go.form3-client.com/account"
+	"go.form3-client.com/accountload"
 )
 
 type result struct {
@@ -24,21 +25,38 @@ type result struct {
 
 func TestConcurrentCreates(t *testing.T) {
 	iterations := 20
-	resultChan := make(chan *result, iterations)
 	hc := http.Client{Timeout: account.ClientTimeout}
-	ac := account.NewAccountClient(fetchAPIHostName(), &hc)
+	ac := newAccountClient(t, &hc)
+	accounts := make([]*account.AccountData, iterations)
+	for i := range accounts {
+		accounts[i] = AccountDataFactory.MustCreate().(*account.AccountData)
+	}
 
-	fireConcurrentCreates(ac, iterations, resultChan)
+	results, summary := ac.CreateAccounts(context.Background(), accounts, account.BulkOptions{Concurrency: iterations / 2})
 
-	for i := 0; i < iterations; i++ {
-		select {
-		case res := <-resultChan:
-			assert.NotEqual(t, res.acc.ID, "")
-			assert.Nil(t, res.err)
-		}
+	assert.Equal(t, iterations, summary.Succeeded)
+	for i, res := range results {
+		assert.Nil(t, res.Err)
+		assert.Equal(t, accounts[i].ID, res.Account.ID)
 	}
 }
 
+// A modest steady load is served without failures; cmd/accountload finds out how far it can be pushed
+func TestSteadyMixedLoad(t *testing.T) {
+	hc := http.Client{Timeout: account.ClientTimeout, Transport: accountload.CountAttempts(nil)}
+	ac := newAccountClient(t, &hc)
+
+	report, err := accountload.Run(context.Background(), ac, accountload.Config{
+		Rate:     50,
+		Duration: 5 * time.Second,
+		Mix:      accountload.Mix{Create: 2, Get: 5, Delete: 1},
+	})
+
+	assert.NoError(t, err)
+	t.Log(report)
+	assert.Equal(t, 0, report.Failed(), "errors: %v", report.Errors)
+}
+
 /*
 I am surprised here because according to the API docs I would
 expect a 429 status http.Response from the server.
@@ -56,7 +74,7 @@ func TestHeavyWriteLoad(t *testing.T) {
 	wayTooManyIterations := 1000
 	resultChan := make(chan *result, wayTooManyIterations)
 	hc := http.Client{Timeout: account.ClientTimeout}
-	ac := account.NewAccountClient(fetchAPIHostName(), &hc)
+	ac := newAccountClient(t, &hc)
 
 	fireConcurrentCreates(ac, wayTooManyIterations, resultChan)
 
@@ -79,6 +97,7 @@ func TestHeavyWriteLoad(t *testing.T) {
 	// the second error string is due to the client interrupting connections then retrying to send the POST while the server is overloaded
 }
 
+// fireConcurrentCreates is deliberately unbounded to overload the server; use AccountClient.CreateAccounts otherwise
 func fireConcurrentCreates(ac *account.AccountClient, iterations int, resultChan chan<- *result) {
 	ctx := context.Background()
 	for i := 0; i < iterations; i++ {
@@ -0,0 +1,172 @@
+//go:build soak
+// +build soak
+
+// the soak test runs the client against the in-process fake with faults, no API server is needed;
+// SOAK_DURATION sets how long it runs. It samples the goroutines, the heap, less the accounts the fake stores,
+// and the connections open on the fake, and fails if any of them keeps growing or does not settle back to its
+// baseline once the client is idle. The operations may only fail by timing out or on a broken body, but for the
+// not found and conflicts of responses lost after the API acted on them, under maxLostResponseRate.
+
+package tests
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.form3-client.com/account"
+	"go.form3-client.com/accountload"
+	"go.form3-client.com/accounttest"
+)
+
+const (
+	soakDurationName    = "SOAK_DURATION"
+	defaultSoakDuration = 2 * time.Minute
+	soakSamples         = 40
+	// growth below these is noise rather than a leak
+	goroutineSlack  = 20
+	heapSlack       = 8 << 20
+	connectionSlack = 10
+	growthTolerance = 0.2
+	// deletes whose first attempt went through answer not found on their retry, creates conflict the same way
+	maxLostResponseRate = 0.05
+)
+
+type sample struct {
+	goroutines  int
+	heap        uint64
+	connections int
+	accounts    int
+}
+
+func takeSample(server *accounttest.Server) sample {
+	accounts := len(server.Store.List())
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return sample{goroutines: runtime.NumGoroutine(), heap: stats.HeapAlloc, connections: server.OpenConnections(), accounts: accounts}
+}
+
+// heapPerAccount measures the heap an account held by the fake's store takes, so that the accounts the failed
+// deletes leave behind are told apart from the client growing
+func heapPerAccount() float64 {
+	const n = 1000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	store := accounttest.NewMemoryStore()
+	for i := 0; i < n; i++ {
+		store.Put(&account.AccountData{ID: uuid.New().String(), OrganisationID: uuid.New().String(), Type: "accounts",
+			Attributes: &account.AccountAttributes{Country: "GB", Name: []string{"load", "test"}}})
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(store)
+	return float64(after.HeapAlloc-before.HeapAlloc) / n
+}
+
+// growing tells whether the samples keep going up: the last third is well above the first one and the
+// middle third is not below it, so a single spike is not mistaken for a leak
+func growing(values []float64, slack float64) bool {
+	third := len(values) / 3
+	mean := func(part []float64) float64 {
+		sum := 0.0
+		for _, v := range part {
+			sum += v
+		}
+		return sum / float64(len(part))
+	}
+	first, middle, last := mean(values[:third]), mean(values[third:2*third]), mean(values[2*third:])
+	return middle >= first && last > first+slack && last > first*(1+growthTolerance)
+}
+
+func TestSoak(t *testing.T) {
+	duration := defaultSoakDuration
+	if fromEnv, ok := os.LookupEnv(soakDurationName); ok {
+		parsed, err := time.ParseDuration(fromEnv)
+		require.NoError(t, err)
+		duration = parsed
+	}
+	zerolog.SetGlobalLevel(zerolog.Disabled) // thousands of retries are expected
+	defer zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	timeout := 2 * time.Second
+	server := accounttest.NewFaultyServer(1, accounttest.Rule{Faults: []accounttest.Fault{
+		{Kind: accounttest.FaultLatency, Probability: 0.02, Latency: 2 * timeout}, // outlives the caller
+		{Kind: accounttest.FaultLatency, Probability: 0.1, Latency: 50 * time.Millisecond},
+		{Kind: accounttest.FaultServerError, Probability: 0.05},
+		{Kind: accounttest.FaultTooManyRequests, Probability: 0.02},
+		{Kind: accounttest.FaultHTMLError, Probability: 0.02},
+		{Kind: accounttest.FaultConnectionReset, Probability: 0.02},
+		{Kind: accounttest.FaultTruncatedBody, Probability: 0.01},
+		{Kind: accounttest.FaultMalformedJSON, Probability: 0.01},
+	}})
+	defer server.Close()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	hc := &http.Client{Timeout: timeout, Transport: accountload.CountAttempts(transport)}
+	client, err := account.NewAccountClient(server.URL, hc)
+	require.NoError(t, err)
+	perAccount := heapPerAccount()
+	baseline := takeSample(server)
+
+	// WHEN
+	done := make(chan *accountload.Report, 1)
+	go func() {
+		report, err := accountload.Run(context.Background(), client, accountload.Config{
+			Rate:        100,
+			Concurrency: 20,
+			Duration:    duration,
+			Mix:         accountload.Mix{Create: 1, Get: 3, Delete: 1},
+			Timeout:     timeout,
+		})
+		assert.NoError(t, err)
+		done <- report
+	}()
+
+	var samples []sample
+	ticker := time.NewTicker(duration / soakSamples)
+	defer ticker.Stop()
+	var report *accountload.Report
+	for report == nil {
+		select {
+		case report = <-done:
+		case <-ticker.C:
+			samples = append(samples, takeSample(server))
+		}
+	}
+	t.Log(report)
+	require.GreaterOrEqual(t, len(samples), 6, "too few samples, run for longer")
+
+	// THEN
+	// the faults fail operations by timing them out or by breaking their bodies; a response lost after the API
+	// acted on it is answered not found or conflict on the retry, which must stay rare
+	for class := range report.Errors {
+		assert.Contains(t, []string{"timeout", "other", "not_found", "conflict"}, class)
+	}
+	lost := report.Errors["not_found"] + report.Errors["conflict"]
+	assert.LessOrEqual(t, float64(lost), maxLostResponseRate*float64(report.Requests), "too many not found or conflicts: %v", report.Errors)
+
+	samples = samples[len(samples)/5:] // the first samples are the pools warming up
+	goroutines, heap, connections := make([]float64, len(samples)), make([]float64, len(samples)), make([]float64, len(samples))
+	for i, s := range samples {
+		// failed deletes leave accounts behind; they are the store growing, not the client
+		storeHeap := float64(s.accounts) * perAccount
+		goroutines[i], heap[i], connections[i] = float64(s.goroutines), float64(s.heap)-storeHeap, float64(s.connections)
+	}
+	t.Logf("baseline %+v, %.0f bytes per stored account, samples %+v", baseline, perAccount, samples)
+	assert.False(t, growing(goroutines, goroutineSlack), "the goroutines keep growing: %v", goroutines)
+	assert.False(t, growing(heap, heapSlack), "the heap keeps growing: %v", heap)
+	assert.False(t, growing(connections, connectionSlack), "the open connections keep growing: %v", connections)
+
+	// once idle, the client is back to where it started
+	transport.CloseIdleConnections()
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= baseline.goroutines+goroutineSlack/2 && server.OpenConnections() <= baseline.connections
+	}, 10*time.Second, 100*time.Millisecond, "the goroutines and connections did not go back to %+v", baseline)
+}
@@ -4,12 +4,24 @@
 package tests
 
 import (
+	"hash/fnv"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.form3-client.com/account"
+	"go.form3-client.com/cassette"
 )
 
 const (
 	hostAddressName = "HOST_ADDRESS"
 	defaultHost     = "http://localhost:8080"
+	cassetteDirName = "CASSETTE_DIR"
+	defaultCassette = "testdata/cassettes"
 )
 
 func fetchAPIHostName() string {
@@ -18,3 +30,60 @@ func fetchAPIHostName() string {
 	}
 	return defaultHost
 }
+
+func newAccountClient(t *testing.T, httpClient *http.Client) *account.AccountClient {
+	useCassette(t, httpClient)
+	client, err := account.NewAccountClient(fetchAPIHostName(), httpClient)
+	require.NoError(t, err)
+	return client
+}
+
+// testData returns the generator of the test's accounts. With CASSETTE_MODE set it is seeded from the test name,
+// so a replay sends the very requests that were recorded; otherwise from the clock, so that the ids of one run
+// do not clash with the accounts an earlier run left in the API.
+func testData(t *testing.T) *generator {
+	mode, err := cassette.ModeFromEnv()
+	require.NoError(t, err)
+	if mode == cassette.ModeOff {
+		return newGenerator(time.Now().UnixNano())
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(t.Name()))
+	return newGenerator(int64(hash.Sum64()))
+}
+
+// useCassette records or replays the requests of the test when CASSETTE_MODE is set
+func useCassette(t *testing.T, httpClient *http.Client) {
+	mode, err := cassette.ModeFromEnv()
+	require.NoError(t, err)
+	if mode == cassette.ModeOff {
+		return
+	}
+
+	dir := defaultCassette
+	if fromEnv, ok := os.LookupEnv(cassetteDirName); ok {
+		dir = fromEnv
+	}
+	path := filepath.Join(dir, strings.ReplaceAll(t.Name(), "/", "_")+".json")
+
+	switch mode {
+	case cassette.ModeRecord:
+		recorder := cassette.NewRecorder(httpClient.Transport, cassette.RecorderOptions{})
+		httpClient.Transport = recorder
+		t.Cleanup(func() {
+			if err := recorder.Save(path); err != nil {
+				t.Errorf("could not save the cassette: %s", err)
+			}
+		})
+	case cassette.ModeReplay:
+		recorded, err := cassette.Load(path)
+		require.NoError(t, err, "record the cassette first with %s=%s", cassette.ModeEnv, cassette.ModeRecord)
+		replayer := cassette.NewReplayer(recorded, cassette.MatchRequest)
+		httpClient.Transport = replayer
+		t.Cleanup(func() {
+			if unused := replayer.Unused(); unused > 0 {
+				t.Errorf("%d recorded interactions were not replayed, record the cassette again", unused)
+			}
+		})
+	}
+}